@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nelwhix/cachefik/internal/cache"
+)
+
+// Admin serves the cache management API. It is meant for a separate,
+// internal listener as it is not authenticated.
+type Admin struct {
+	Cache cache.Cache
+	// Warmer serves the warm endpoint, which is unavailable when nil
+	Warmer *Warmer
+	// Cluster, when set, is sent the tag, prefix, regex and all purges so
+	// every peer's cache is purged, each holding the keys it owns
+	Cluster *Cluster
+
+	mux *http.ServeMux
+}
+
+func NewAdmin(c cache.Cache) *Admin {
+	a := &Admin{Cache: c, mux: http.NewServeMux()}
+	a.mux.HandleFunc("GET /stats", a.stats)
+	a.mux.HandleFunc("GET /entries", a.listEntries)
+	a.mux.HandleFunc("GET /entry", a.inspectEntry)
+	a.mux.HandleFunc("POST /purge", a.purge)
+	a.mux.HandleFunc("POST /warm", a.warm)
+
+	return a
+}
+
+type entryInfo struct {
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	Size   int    `json:"size"`
+	// Age and TTL are in seconds, TTL being the remaining freshness
+	Age   int64 `json:"age"`
+	TTL   int64 `json:"ttl"`
+	Stale bool  `json:"stale"`
+	// Header and RequestHeader are only set when inspecting a single URL
+	Header        http.Header `json:"header,omitempty"`
+	RequestHeader http.Header `json:"request_header,omitempty"`
+}
+
+type cacheStats struct {
+	Keys     int `json:"keys"`
+	Variants int `json:"variants"`
+	// Bytes is only reported by caches tracking their memory usage
+	Bytes *int64 `json:"bytes,omitempty"`
+}
+
+type purgeResult struct {
+	Purged int `json:"purged"`
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *Admin) stats(w http.ResponseWriter, r *http.Request) {
+	if !a.canList(w) {
+		return
+	}
+
+	var stats cacheStats
+	keys := make(map[string]struct{})
+	cache.RangeMetadata(a.Cache, func(key string, _ cache.Entry, _ int) bool {
+		keys[key] = struct{}{}
+		stats.Variants++
+		return true
+	})
+	stats.Keys = len(keys)
+
+	// A cluster reports the usage of its local cache
+	store := a.Cache
+	if c, ok := store.(clusterCache); ok {
+		store = c.Cache
+	}
+	if u, ok := store.(interface{ Usage() int64 }); ok {
+		bytes := u.Usage()
+		stats.Bytes = &bytes
+	}
+
+	sendJSON(w, stats)
+}
+
+// listEntries lists the stored entries, optionally filtered by the prefix
+// and regex query parameters.
+func (a *Admin) listEntries(w http.ResponseWriter, r *http.Request) {
+	match, err := urlMatcher(r.URL.Query())
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !a.canList(w) {
+		return
+	}
+
+	entries := []entryInfo{}
+	cache.RangeMetadata(a.Cache, func(key string, entry cache.Entry, size int) bool {
+		if u, err := cache.KeyURL(key); err == nil && match(u) {
+			entries = append(entries, newEntryInfo(key, u, entry, size))
+		}
+		return true
+	})
+
+	sendJSON(w, entries)
+}
+
+// inspectEntry returns every variant stored for the url query parameter,
+// including their headers.
+func (a *Admin) inspectEntry(w http.ResponseWriter, r *http.Request) {
+	u, err := absoluteURL(r.URL.Query().Get("url"))
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key := proxyKey(u)
+	entries := []entryInfo{}
+	for _, entry := range cache.Variants(a.Cache, key) {
+		info := newEntryInfo(key, u, entry, entry.Size())
+		info.Header = entry.Header
+		info.RequestHeader = entry.RequestHeader
+		entries = append(entries, info)
+	}
+
+	if len(entries) == 0 {
+		sendJSONError(w, "entry not found", http.StatusNotFound)
+		return
+	}
+
+	sendJSON(w, entries)
+}
+
+// purge removes the entry for the url query parameter, the entries tagged
+// with any of the tag parameters, the entries matching the prefix or regex
+// parameters, or every entry with all=true.
+func (a *Admin) purge(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if tags := query["tag"]; len(tags) > 0 {
+		purged := 0
+		for _, tag := range tags {
+			purged += cache.PurgeTag(a.Cache, tag)
+		}
+		if a.Cluster != nil {
+			purged += a.Cluster.Purge(url.Values{"tag": tags})
+		}
+
+		slog.Info("cache purged", "tags", tags, "purged", purged)
+		sendJSON(w, purgeResult{purged})
+		return
+	}
+
+	if rawURL := query.Get("url"); rawURL != "" {
+		u, err := absoluteURL(rawURL)
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		key := proxyKey(u)
+		purged := 0
+		if len(cache.Variants(a.Cache, key)) > 0 {
+			purged = 1
+		}
+		a.Cache.Delete(key)
+
+		sendJSON(w, purgeResult{purged})
+		return
+	}
+
+	// Purging everything must be explicit, an empty filter is a mistake
+	if query.Get("prefix") == "" && query.Get("regex") == "" && query.Get("all") != "true" {
+		sendJSONError(w, "one of url, tag, prefix, regex or all=true is required", http.StatusBadRequest)
+		return
+	}
+
+	match, err := urlMatcher(query)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !a.canList(w) {
+		return
+	}
+
+	keys := make(map[string]struct{})
+	cache.RangeMetadata(a.Cache, func(key string, _ cache.Entry, _ int) bool {
+		if u, err := cache.KeyURL(key); err == nil && match(u) {
+			keys[key] = struct{}{}
+		}
+		return true
+	})
+
+	for key := range keys {
+		a.Cache.Delete(key)
+	}
+
+	purged := len(keys)
+	if a.Cluster != nil {
+		peerQuery := url.Values{}
+		for _, param := range []string{"prefix", "regex", "all"} {
+			if v := query.Get(param); v != "" {
+				peerQuery.Set(param, v)
+			}
+		}
+		purged += a.Cluster.Purge(peerQuery)
+	}
+
+	slog.Info("cache purged", "prefix", query.Get("prefix"), "regex", query.Get("regex"), "purged", purged)
+	sendJSON(w, purgeResult{purged})
+}
+
+// canList reports whether the cache can list its entries, answering 501
+// when it can't as listing them would silently find nothing.
+func (a *Admin) canList(w http.ResponseWriter) bool {
+	if cache.CanList(a.Cache) {
+		return true
+	}
+
+	sendJSONError(w, "the cache backend can't list its entries", http.StatusNotImplemented)
+	return false
+}
+
+// warm requests the URLs listed in the body and in the sitemaps given by the
+// sitemap query parameters, streaming a JSON result per line as they
+// complete. The concurrency and rate parameters override the Warmer's.
+func (a *Admin) warm(w http.ResponseWriter, r *http.Request) {
+	if a.Warmer == nil {
+		sendJSONError(w, "cache warming is unavailable", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	warmer := *a.Warmer
+	if v := query.Get("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxWarmConcurrency {
+			sendJSONError(w, fmt.Sprintf("concurrency must be an integer between 1 and %d", maxWarmConcurrency), http.StatusBadRequest)
+			return
+		}
+		warmer.Concurrency = n
+	}
+	if v := query.Get("rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 {
+			sendJSONError(w, "rate must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		warmer.Rate = rate
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSitemapSize))
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	urls, err := warmer.URLs(r.Context(), data)
+	if err != nil {
+		sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, sitemap := range query["sitemap"] {
+		listed, err := warmer.Sitemap(r.Context(), sitemap)
+		if err != nil {
+			sendJSONError(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		urls = append(urls, listed...)
+	}
+
+	if len(urls) == 0 {
+		sendJSONError(w, "no URLs to warm", http.StatusBadRequest)
+		return
+	}
+	for _, u := range urls {
+		if _, err := absoluteURL(u); err != nil {
+			sendJSONError(w, fmt.Sprintf("%s: %v", u, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Warming takes as long as the list needs
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	counts := make(map[string]int)
+	warmer.Warm(r.Context(), urls, func(res WarmResult) {
+		counts[res.Result]++
+		_ = enc.Encode(res)
+		_ = rc.Flush()
+	})
+
+	slog.Info("cache warmed", "urls", len(urls), "stored", counts[WarmStored], "cached", counts[WarmCached],
+		"bypassed", counts[WarmBypassed], "failed", counts[WarmFailed])
+}
+
+// newEntryInfo describes an entry whose body may not be loaded, size being
+// the size of the complete entry.
+func newEntryInfo(key string, u *url.URL, entry cache.Entry, size int) entryInfo {
+	return entryInfo{
+		Key:    key,
+		URL:    u.String(),
+		Status: entry.StatusCode,
+		Size:   size,
+		Age:    int64(entry.Age() / time.Second),
+		TTL:    int64(max(time.Until(entry.ExpiresAt), 0) / time.Second),
+		Stale:  entry.Expired(),
+	}
+}
+
+// urlMatcher matches URLs whose path starts with the prefix parameter and
+// whose full URL matches the regex parameter, when set.
+func urlMatcher(query url.Values) (func(*url.URL) bool, error) {
+	prefix := query.Get("prefix")
+
+	var re *regexp.Regexp
+	if expr := query.Get("regex"); expr != "" {
+		var err error
+		if re, err = regexp.Compile(expr); err != nil {
+			return nil, errors.New("invalid regex: " + err.Error())
+		}
+	}
+
+	return func(u *url.URL) bool {
+		if !strings.HasPrefix(u.Path, prefix) {
+			return false
+		}
+
+		return re == nil || re.MatchString(u.String())
+	}, nil
+}
+
+// proxyKey returns the key the proxy stores u under. Cachefik only listens
+// on plain HTTP, TLS being terminated in front of it, so every key uses the
+// http scheme whatever the scheme clients see.
+func proxyKey(u *url.URL) string {
+	plain := *u
+	plain.Scheme = "http"
+
+	return cache.URLKey(&plain)
+}
+
+func absoluteURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return nil, errors.New("url must be an absolute URL such as http://example.com/path")
+	}
+
+	return u, nil
+}
+
+func sendJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nelwhix/cachefik/internal/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmin(t *testing.T) {
+	newAdmin := func() (*Admin, cache.Cache) {
+		c := cache.NewMemoryCache(0)
+		for _, key := range []string{
+			"GET:http://example.com/?",
+			"GET:http://example.com/api/items?page=1",
+			"GET:http://example.com/api/items/42?",
+			"GET:http://example.com/assets/app.js?",
+		} {
+			c.Set(key, cache.Entry{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Surrogate-Key": []string{"section-" + strings.Split(key, "/")[3]}},
+				Body:       []byte("body"),
+				ExpiresAt:  time.Now().Add(1 * time.Minute),
+			})
+		}
+
+		return NewAdmin(c), c
+	}
+
+	do := func(a *Admin, method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w
+	}
+
+	count := func(c cache.Cache) int {
+		n := 0
+		c.Range(func(string, cache.Entry) bool {
+			n++
+			return true
+		})
+		return n
+	}
+
+	t.Run("Stats", func(t *testing.T) {
+		a, c := newAdmin()
+
+		w := do(a, http.MethodGet, "/stats")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var stats cacheStats
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+		assert.Equal(t, 4, stats.Keys)
+		assert.Equal(t, 4, stats.Variants)
+		assert.Equal(t, c.(*cache.MemoryCache).Usage(), *stats.Bytes)
+	})
+
+	t.Run("List entries", func(t *testing.T) {
+		a, _ := newAdmin()
+
+		w := do(a, http.MethodGet, "/entries?prefix=/api")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var entries []entryInfo
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&entries))
+		assert.Len(t, entries, 2)
+		for _, e := range entries {
+			assert.Equal(t, http.StatusOK, e.Status)
+			assert.Equal(t, len("body")+len("Surrogate-Key")+len("section-api"), e.Size)
+			assert.InDelta(t, 60, e.TTL, 1)
+			assert.False(t, e.Stale)
+			assert.Nil(t, e.Header)
+		}
+	})
+
+	t.Run("Inspect entry", func(t *testing.T) {
+		a, _ := newAdmin()
+
+		w := do(a, http.MethodGet, "/entry?url=http://example.com/api/items/42")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var entries []entryInfo
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&entries))
+		assert.Len(t, entries, 1)
+		assert.Equal(t, "GET:http://example.com/api/items/42?", entries[0].Key)
+		assert.Equal(t, "section-api", entries[0].Header.Get("Surrogate-Key"))
+
+		w = do(a, http.MethodGet, "/entry?url=https://example.com/api/items/42")
+		assert.Equal(t, http.StatusOK, w.Code, "keys always use the http scheme")
+
+		w = do(a, http.MethodGet, "/entry?url=http://example.com/missing")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = do(a, http.MethodGet, "/entry?url=/api/items/42")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	testCases := []struct {
+		name      string
+		query     string
+		code      int
+		purged    int
+		remaining int
+	}{
+		{
+			name:      "Purge URL",
+			query:     "url=http://example.com/api/items?page=1",
+			code:      http.StatusOK,
+			purged:    1,
+			remaining: 3,
+		},
+		{
+			name:      "Purge https URL",
+			query:     "url=https://example.com/api/items?page=1",
+			code:      http.StatusOK,
+			purged:    1,
+			remaining: 3,
+		},
+		{
+			name:      "Purge missing URL",
+			query:     "url=http://example.com/missing",
+			code:      http.StatusOK,
+			purged:    0,
+			remaining: 4,
+		},
+		{
+			name:      "Purge tags",
+			query:     "tag=section-api&tag=section-assets",
+			code:      http.StatusOK,
+			purged:    3,
+			remaining: 1,
+		},
+		{
+			name:      "Purge prefix",
+			query:     "prefix=/api/",
+			code:      http.StatusOK,
+			purged:    2,
+			remaining: 2,
+		},
+		{
+			name:      "Purge regex",
+			query:     `regex=\.js$`,
+			code:      http.StatusOK,
+			purged:    1,
+			remaining: 3,
+		},
+		{
+			name:      "Purge all",
+			query:     "all=true",
+			code:      http.StatusOK,
+			purged:    4,
+			remaining: 0,
+		},
+		{
+			name:      "No filter",
+			code:      http.StatusBadRequest,
+			remaining: 4,
+		},
+		{
+			name:      "Invalid regex",
+			query:     "regex=(",
+			code:      http.StatusBadRequest,
+			remaining: 4,
+		},
+	}
+
+	t.Run("Listing doesn't load bodies", func(t *testing.T) {
+		c, err := cache.NewDiskCache(t.TempDir(), 0)
+		assert.NoError(t, err)
+		c.Set("GET:http://example.com/big?", cache.Entry{Body: make([]byte, 1000), ExpiresAt: time.Now().Add(1 * time.Minute)})
+		a := NewAdmin(unrangeableCache{c, t})
+
+		w := do(a, http.MethodGet, "/entries")
+		var entries []entryInfo
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&entries))
+		assert.Len(t, entries, 1)
+		assert.Equal(t, 1000, entries[0].Size)
+
+		assert.Equal(t, http.StatusOK, do(a, http.MethodGet, "/stats").Code)
+		assert.Equal(t, http.StatusOK, do(a, http.MethodPost, "/purge?prefix=/big").Code)
+		assert.Empty(t, cache.Variants(c, "GET:http://example.com/big?"))
+	})
+
+	t.Run("URL lookups don't scan the cache", func(t *testing.T) {
+		_, c := newAdmin()
+		a := NewAdmin(unrangeableCache{c, t})
+
+		assert.Equal(t, http.StatusOK, do(a, http.MethodGet, "/entry?url=http://example.com/").Code)
+		assert.Equal(t, http.StatusOK, do(a, http.MethodPost, "/purge?url=http://example.com/").Code)
+	})
+
+	t.Run("Unlistable cache", func(t *testing.T) {
+		_, c := newAdmin()
+		a := NewAdmin(unlistableCache{c})
+
+		for _, target := range []string{"/stats", "/entries"} {
+			assert.Equal(t, http.StatusNotImplemented, do(a, http.MethodGet, target).Code, target)
+		}
+		for _, target := range []string{"/purge?all=true", "/purge?prefix=http://example.com/api", "/purge?regex=app"} {
+			assert.Equal(t, http.StatusNotImplemented, do(a, http.MethodPost, target).Code, target)
+		}
+		assert.Equal(t, 4, count(c))
+
+		assert.Equal(t, http.StatusOK, do(a, http.MethodPost, "/purge?tag=section-assets").Code)
+		assert.Equal(t, http.StatusOK, do(a, http.MethodPost, "/purge?url=http://example.com/").Code)
+		assert.Equal(t, 2, count(c))
+	})
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, c := newAdmin()
+
+			w := do(a, http.MethodPost, "/purge?"+tc.query)
+			assert.Equal(t, tc.code, w.Code)
+			if tc.code == http.StatusOK {
+				var result purgeResult
+				assert.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+				assert.Equal(t, tc.purged, result.Purged)
+			}
+			assert.Equal(t, tc.remaining, count(c))
+		})
+	}
+}
+
+// unrangeableCache fails the test when the entries are iterated with their
+// bodies.
+type unrangeableCache struct {
+	cache.Cache
+	t *testing.T
+}
+
+func (c unrangeableCache) Range(func(string, cache.Entry) bool) {
+	c.t.Error("Range called")
+}
+
+func (c unrangeableCache) Variants(key string) []cache.Entry {
+	return cache.Variants(c.Cache, key)
+}
+
+func (c unrangeableCache) RangeMetadata(fn func(string, cache.Entry, int) bool) {
+	cache.RangeMetadata(c.Cache, fn)
+}
+
+// unlistableCache is a cache that can't list its entries, like memcached.
+type unlistableCache struct {
+	cache.Cache
+}
+
+func (c unlistableCache) CanList() bool {
+	return false
+}
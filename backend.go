@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/Nelwhix/cachefik/internal/cache"
+	"github.com/Nelwhix/cachefik/internal/config"
+)
+
+// newCache builds the cache backend selected by CACHEFIK_CACHE_BACKEND.
+func newCache(cfg *config.Config) (cache.Cache, error) {
+	if strings.ToLower(cfg.CacheBackend) != "tiered" {
+		return newBackend(cfg, cfg.CacheBackend)
+	}
+
+	if strings.ToLower(cfg.CacheL2) == "tiered" {
+		return nil, fmt.Errorf("invalid L2 cache backend %q", cfg.CacheL2)
+	}
+
+	l1, err := newMemoryCache(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	l2, err := newBackend(cfg, cfg.CacheL2)
+	if err != nil {
+		closeCache(l1)
+		return nil, err
+	}
+
+	return cache.NewTieredCache(l1, l2, cfg.L1MaxEntry), nil
+}
+
+func newBackend(cfg *config.Config, name string) (cache.Cache, error) {
+	switch strings.ToLower(name) {
+	case "memory":
+		return newMemoryCache(cfg)
+	case "disk":
+		return cache.NewDiskCache(cfg.DiskDir, cfg.DiskSize)
+	case "redis":
+		return cache.NewRedisCache(cache.RedisOptions{
+			Addr:      cfg.RedisAddr,
+			Password:  cfg.RedisPassword,
+			DB:        int(cfg.RedisDB),
+			PoolSize:  int(cfg.RemotePoolSize),
+			Timeout:   cfg.RemoteTimeout,
+			Prefix:    "cachefik:",
+			Retention: cfg.RemoteRetention,
+		}), nil
+	case "memcached":
+		return cache.NewMemcachedCache(cache.MemcachedOptions{
+			Addr:        cfg.MemcachedAddr,
+			PoolSize:    int(cfg.RemotePoolSize),
+			Timeout:     cfg.RemoteTimeout,
+			Prefix:      "cachefik:",
+			Retention:   cfg.RemoteRetention,
+			MaxItemSize: int(cfg.MemcachedItemSize),
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", name)
+	}
+}
+
+func newMemoryCache(cfg *config.Config) (cache.Cache, error) {
+	eviction := cache.EvictionPolicy(strings.ToLower(cfg.EvictionPolicy))
+	if !eviction.Valid() {
+		return nil, fmt.Errorf("unknown eviction policy %q", cfg.EvictionPolicy)
+	}
+
+	if cfg.CacheShards > 1 {
+		// Each shard evicts within its own part of the budget
+		if shardBytes := cfg.CacheMemory / cfg.CacheShards; cfg.CacheMemory > 0 && cfg.MaxCacheSize > shardBytes {
+			slog.Warn("Responses larger than a cache shard's budget won't be cached",
+				"shard_bytes", shardBytes, "max_cache_size", cfg.MaxCacheSize, "shards", cfg.CacheShards)
+		}
+
+		c := cache.NewShardedCache(int(cfg.CacheShards), cfg.CacheMemory, eviction)
+		c.StartJanitor(cfg.JanitorInterval)
+		return c, nil
+	}
+
+	c := cache.NewMemoryCacheWithPolicy(cfg.CacheMemory, eviction)
+	c.StartJanitor(cfg.JanitorInterval)
+	return c, nil
+}
+
+// closeCache stops the background work of the backends having any.
+func closeCache(c cache.Cache) {
+	if closer, ok := c.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// restoreSnapshot loads the snapshot saved by the previous instance into the
+// memory cache. The snapshot is removed once loaded, so entries purged after
+// a crash don't come back on the next start.
+func restoreSnapshot(cfg *config.Config, c cache.Cache) {
+	if cfg.SnapshotPath == "" {
+		return
+	}
+	if strings.ToLower(cfg.CacheBackend) != "memory" {
+		slog.Warn("Cache snapshots only apply to the memory backend", "backend", cfg.CacheBackend)
+		return
+	}
+
+	loaded, err := cache.LoadSnapshot(c, cfg.SnapshotPath)
+	if err != nil {
+		slog.Warn("Ignoring cache snapshot", "path", cfg.SnapshotPath, "error", err)
+	}
+	if loaded > 0 {
+		slog.Info("Restored cache snapshot", "path", cfg.SnapshotPath, "entries", loaded)
+	}
+
+	if err := os.Remove(cfg.SnapshotPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Removing cache snapshot failed", "path", cfg.SnapshotPath, "error", err)
+	}
+}
+
+// saveSnapshot saves the memory cache for the next instance.
+func saveSnapshot(cfg *config.Config, c cache.Cache) {
+	if cfg.SnapshotPath == "" || strings.ToLower(cfg.CacheBackend) != "memory" {
+		return
+	}
+
+	written, err := cache.WriteSnapshot(c, cfg.SnapshotPath)
+	if err != nil {
+		slog.Error("Saving cache snapshot failed", "path", cfg.SnapshotPath, "error", err)
+		return
+	}
+
+	slog.Info("Saved cache snapshot", "path", cfg.SnapshotPath, "entries", written)
+}
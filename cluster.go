@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nelwhix/cachefik/internal/cache"
+	"github.com/Nelwhix/cachefik/internal/cluster"
+	"github.com/Nelwhix/cachefik/internal/config"
+	"github.com/Nelwhix/cachefik/internal/provider/docker"
+)
+
+// deletePath is the cluster endpoint removing a key from the owner's cache.
+const deletePath = "/_cachefik/delete"
+
+// purgePath is the cluster endpoint purging a peer's cache, taking the admin
+// API's purge parameters.
+const purgePath = "/_cachefik/purge"
+
+const (
+	// peerDialTimeout bounds connecting to a peer, which is on the same
+	// network.
+	peerDialTimeout = 1 * time.Second
+	// peerCooldown is how long a peer that failed is considered down,
+	// requests it owns being served locally.
+	peerCooldown = 10 * time.Second
+	// peerDeleteTimeout bounds deleting a key from its owner.
+	peerDeleteTimeout = 2 * time.Second
+	// peerPurgeTimeout bounds purging a peer, which may go through its whole
+	// cache.
+	peerPurgeTimeout = 30 * time.Second
+)
+
+// Cluster spreads the cache over Cachefik peers. Each cacheable request is
+// served by the peer owning its key on the hash ring, which does the single
+// upstream fill, and the other peers relay its response. Peers reach each
+// other on a separate listener, see PeerHandler.
+type Cluster struct {
+	// Self is this peer's address on the ring.
+	Self   string
+	Ring   *cluster.Ring
+	Client *http.Client
+	// Cache is the local cache, holding the keys this peer owns.
+	Cache cache.Cache
+
+	mu       sync.Mutex
+	breakers map[string]*cache.Breaker
+}
+
+func NewCluster(self string, ring *cluster.Ring, local cache.Cache, timeout time.Duration) *Cluster {
+	return &Cluster{
+		Self: self,
+		Ring: ring,
+		Client: &http.Client{
+			Timeout: timeout,
+			// Responses are relayed as they are
+			Transport: &http.Transport{
+				DialContext:        (&net.Dialer{Timeout: peerDialTimeout}).DialContext,
+				DisableCompression: true,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Cache: local,
+	}
+}
+
+// owner returns the peer owning key, false when it's this peer.
+func (c *Cluster) owner(key string) (string, bool) {
+	owner, ok := c.Ring.Owner(key)
+	return owner, ok && owner != c.Self
+}
+
+// breaker returns the circuit breaker of peer, which skips it for
+// peerCooldown after a failure.
+func (c *Cluster) breaker(peer string) *cache.Breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.breakers[peer]
+	if !ok {
+		if c.breakers == nil {
+			c.breakers = make(map[string]*cache.Breaker)
+		}
+		b = cache.NewBreaker(1, peerCooldown)
+		c.breakers[peer] = b
+	}
+
+	return b
+}
+
+// record counts the outcome of a request to peer on its breaker.
+func (c *Cluster) record(peer string, b *cache.Breaker, err error) {
+	if b.Record(err) {
+		slog.Warn("cluster peer unreachable, serving its keys locally", "peer", peer, "error", err)
+	}
+}
+
+// Handler relays the cacheable requests owned by another peer to it, and
+// serves the rest with next. Requests are served locally when the owner is
+// unreachable.
+func (c *Cluster) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cache.CanCacheRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		owner, ok := c.owner(cache.Key(r))
+		if !ok || !c.relay(w, r, owner) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// relay proxies the request to its owner. It returns false when the owner
+// is down or couldn't be reached, before anything was written.
+func (c *Cluster) relay(w http.ResponseWriter, r *http.Request, owner string) bool {
+	b := c.breaker(owner)
+	if !b.Allow() {
+		return false
+	}
+
+	outRequest := r.Clone(r.Context())
+	outRequest.URL.Scheme = "http"
+	outRequest.URL.Host = owner
+	outRequest.RequestURI = ""
+	removeHopByHopHeaders(outRequest.Header)
+	addForwardedHeaders(outRequest)
+
+	resp, err := c.Client.Do(outRequest)
+	c.record(owner, b, err)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	copyHeaders(w.Header(), resp.Header)
+	removeHopByHopHeaders(w.Header())
+	w.WriteHeader(resp.StatusCode)
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		slog.Error("streaming failed", "peer", owner, "error", err)
+	}
+
+	return true
+}
+
+// PeerHandler serves the requests relayed by other peers with next, without
+// relaying them further, and the cluster endpoints.
+func (c *Cluster) PeerHandler(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+deletePath, func(w http.ResponseWriter, r *http.Request) {
+		c.Cache.Delete(r.FormValue("key"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	// Only the local cache is purged, the purge is broadcast by the peer
+	// receiving it
+	mux.Handle("POST "+purgePath, http.StripPrefix("/_cachefik", NewAdmin(c.Cache)))
+	mux.Handle("/", next)
+
+	return mux
+}
+
+// Delete removes key from the local cache and from its owner's, unless the
+// owner is down.
+func (c *Cluster) Delete(key string) {
+	c.Cache.Delete(key)
+
+	owner, ok := c.owner(key)
+	if !ok {
+		return
+	}
+
+	b := c.breaker(owner)
+	if !b.Allow() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), peerDeleteTimeout)
+	defer cancel()
+
+	err := c.deleteFromOwner(ctx, owner, key)
+	c.record(owner, b, err)
+	if err != nil {
+		slog.Warn("cluster delete failed", "peer", owner, "key", key, "error", err)
+	}
+}
+
+func (c *Cluster) deleteFromOwner(ctx context.Context, owner, key string) error {
+	body := strings.NewReader(url.Values{"key": {key}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+owner+deletePath, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Purge sends an admin API purge to every other peer, except those that are
+// down, and returns how many entries they purged.
+func (c *Cluster) Purge(query url.Values) int {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	purged := 0
+	for _, peer := range c.Ring.Peers() {
+		if peer == c.Self {
+			continue
+		}
+
+		b := c.breaker(peer)
+		if !b.Allow() {
+			slog.Warn("cluster peer down, not purged", "peer", peer)
+			continue
+		}
+
+		wg.Go(func() {
+			n, err := c.purgePeer(peer, b, query)
+			if err != nil {
+				slog.Warn("cluster purge failed", "peer", peer, "error", err)
+				return
+			}
+
+			mu.Lock()
+			purged += n
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	return purged
+}
+
+func (c *Cluster) purgePeer(peer string, b *cache.Breaker, query url.Values) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), peerPurgeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+peer+purgePath+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := c.Client.Do(req)
+	c.record(peer, b, err)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	var result purgeResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	return result.Purged, nil
+}
+
+// WatchPeers updates the ring with the peers returned by discover every
+// interval until ctx is done. The peers aren't refreshed when interval isn't
+// positive.
+func (c *Cluster) WatchPeers(ctx context.Context, interval time.Duration, discover func(context.Context) ([]string, error)) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		peers, err := discover(ctx)
+		if err != nil {
+			slog.Error("cluster peer discovery failed", "error", err)
+			continue
+		}
+
+		slices.Sort(peers)
+		peers = slices.Compact(peers)
+		if !slices.Equal(peers, c.Ring.Peers()) {
+			slog.Info("cluster peers changed", "peers", peers)
+			c.Ring.Set(peers)
+
+			c.mu.Lock()
+			maps.DeleteFunc(c.breakers, func(peer string, _ *cache.Breaker) bool {
+				return !slices.Contains(peers, peer)
+			})
+			c.mu.Unlock()
+		}
+	}
+}
+
+// clusterCache is the cache used by a clustered proxy, deleting keys from
+// their owner as well, so invalidations and purges reach the entries.
+type clusterCache struct {
+	cache.Cache
+	cluster *Cluster
+}
+
+func (c clusterCache) Delete(key string) {
+	c.cluster.Delete(key)
+}
+
+func (c clusterCache) RangeMetadata(fn func(key string, entry cache.Entry, size int) bool) {
+	cache.RangeMetadata(c.Cache, fn)
+}
+
+func (c clusterCache) CanList() bool {
+	return cache.CanList(c.Cache)
+}
+
+// Variants only looks up the local cache.
+func (c clusterCache) Variants(key string) []cache.Entry {
+	return cache.Variants(c.Cache, key)
+}
+
+// PurgeTag only purges the local cache, tags are not tracked by owner. The
+// admin API broadcasts tag purges with Cluster.Purge.
+func (c clusterCache) PurgeTag(tag string) int {
+	return cache.PurgeTag(c.Cache, tag)
+}
+
+// newCluster places the peers listed in the config, or discovered from the
+// Docker labels, on the ring. It also returns the discovery function to
+// watch for peer changes, nil with static peers.
+func newCluster(ctx context.Context, cfg *config.Config, local cache.Cache) (*Cluster, func(context.Context) ([]string, error), error) {
+	ring := cluster.NewRing(int(cfg.ClusterVnodes))
+	self := cfg.ClusterSelf
+
+	var discover func(context.Context) ([]string, error)
+	switch strings.ToLower(cfg.ClusterDiscovery) {
+	case "static":
+		ring.Set(cfg.ClusterPeers)
+	case "docker":
+		peers, err := docker.DiscoverPeers(ctx, cfg.DockerHost, cfg.DockerVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Containers are named after their ID by default
+		hostname, _ := os.Hostname()
+		addrs := make([]string, 0, len(peers))
+		for _, peer := range peers {
+			if self == "" && hostname != "" && strings.HasPrefix(peer.ContainerID, hostname) {
+				self = peer.Addr
+			}
+			addrs = append(addrs, peer.Addr)
+		}
+		ring.Set(addrs)
+
+		discover = func(ctx context.Context) ([]string, error) {
+			peers, err := docker.DiscoverPeers(ctx, cfg.DockerHost, cfg.DockerVersion)
+			if err != nil {
+				return nil, err
+			}
+
+			addrs := make([]string, 0, len(peers))
+			for _, peer := range peers {
+				addrs = append(addrs, peer.Addr)
+			}
+			return addrs, nil
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown cluster discovery %q", cfg.ClusterDiscovery)
+	}
+
+	if self == "" {
+		return nil, nil, errors.New("CACHEFIK_CLUSTER_SELF is required to find this instance among the peers")
+	}
+	if !slices.Contains(ring.Peers(), self) {
+		slog.Warn("this instance is not a cluster peer, it will relay every cacheable request", "self", self)
+	}
+
+	return NewCluster(self, ring, local, cfg.WriteTimeout), discover, nil
+}
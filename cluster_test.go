@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Nelwhix/cachefik/internal/cache"
+	"github.com/Nelwhix/cachefik/internal/cluster"
+	"github.com/Nelwhix/cachefik/internal/provider/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testPeer struct {
+	cluster *Cluster
+	cache   *cache.MemoryCache
+	server  *httptest.Server
+	handler http.Handler
+	// down makes the peer drop the connections it receives
+	down     atomic.Bool
+	requests atomic.Int32
+}
+
+func TestCluster(t *testing.T) {
+	var upstreamRequests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			upstreamRequests.Add(1)
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Surrogate-Key", "items")
+		_, _ = w.Write([]byte("response for " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	newPeers := func(t *testing.T) []*testPeer {
+		peers := make([]*testPeer, 2)
+		var addrs []string
+		for i := range peers {
+			p := &testPeer{cache: cache.NewMemoryCache(0)}
+			p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				p.requests.Add(1)
+				if p.down.Load() {
+					panic(http.ErrAbortHandler)
+				}
+				p.cluster.PeerHandler(p.handler).ServeHTTP(w, r)
+			}))
+			t.Cleanup(p.server.Close)
+			peers[i] = p
+			addrs = append(addrs, strings.TrimPrefix(p.server.URL, "http://"))
+		}
+
+		for i, p := range peers {
+			ring := cluster.NewRing(64)
+			ring.Set(addrs)
+			p.cluster = NewCluster(addrs[i], ring, p.cache, 5*time.Second)
+			p.handler = &Proxy{
+				Services:     []docker.Service{{Rule: "PathPrefix(`/`)", Upstream: backend.URL}},
+				Client:       &http.Client{},
+				Cache:        clusterCache{Cache: p.cache, cluster: p.cluster},
+				MaxCacheSize: 1024 * 1024,
+			}
+		}
+
+		return peers
+	}
+
+	get := func(p *testPeer, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		p.cluster.Handler(p.handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	t.Run("Owner fills once", func(t *testing.T) {
+		peers := newPeers(t)
+		upstreamRequests.Store(0)
+
+		for i := range 20 {
+			for _, p := range peers {
+				w := get(p, fmt.Sprintf("/items/%d", i))
+				assert.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, fmt.Sprintf("response for /items/%d", i), w.Body.String())
+			}
+		}
+		assert.Equal(t, int32(20), upstreamRequests.Load())
+
+		// Every key is stored by its owner only
+		for _, p := range peers {
+			count := 0
+			p.cache.Range(func(key string, _ cache.Entry) bool {
+				owner, _ := p.cluster.Ring.Owner(key)
+				assert.Equal(t, p.cluster.Self, owner)
+				count++
+				return true
+			})
+			assert.NotZero(t, count)
+		}
+	})
+
+	// ownedBy returns a path whose key is owned by p
+	ownedBy := func(p *testPeer) string {
+		for i := 0; ; i++ {
+			path := fmt.Sprintf("/items/%d", i)
+			if owner, _ := p.cluster.Ring.Owner("GET:http://example.com" + path + "?"); owner == p.cluster.Self {
+				return path
+			}
+		}
+	}
+
+	t.Run("Unreachable owner", func(t *testing.T) {
+		peers := newPeers(t)
+		peers[1].server.Close()
+		path := ownedBy(peers[1])
+
+		w := get(peers[0], path)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Equal(t, "HIT", get(peers[0], path).Header().Get("X-Cache"))
+	})
+
+	t.Run("Down owner is skipped", func(t *testing.T) {
+		peers := newPeers(t)
+		peers[1].down.Store(true)
+		path := ownedBy(peers[1])
+
+		assert.Equal(t, "MISS", get(peers[0], path).Header().Get("X-Cache"))
+		assert.Equal(t, "HIT", get(peers[0], path).Header().Get("X-Cache"))
+
+		w := httptest.NewRecorder()
+		peers[0].cluster.Handler(peers[0].handler).ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int32(1), peers[1].requests.Load(), "the owner isn't retried until the cooldown elapses")
+		assert.Equal(t, "MISS", get(peers[0], path).Header().Get("X-Cache"), "the local entry is invalidated")
+	})
+
+	t.Run("No peer refresh", func(t *testing.T) {
+		peers := newPeers(t)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			peers[0].cluster.WatchPeers(context.Background(), 0, func(context.Context) ([]string, error) {
+				t.Error("peers discovered again")
+				return nil, nil
+			})
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(1 * time.Second):
+			t.Fatal("WatchPeers didn't return")
+		}
+	})
+
+	t.Run("Purges reach every peer", func(t *testing.T) {
+		peers := newPeers(t)
+		admin := NewAdmin(peers[0].handler.(*Proxy).Cache)
+		admin.Cluster = peers[0].cluster
+
+		purge := func(target string) int {
+			w := httptest.NewRecorder()
+			admin.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, nil))
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var result purgeResult
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+			return result.Purged
+		}
+
+		for _, target := range []string{"/purge?tag=items", "/purge?prefix=/items/", "/purge?regex=items", "/purge?all=true"} {
+			for i := range 10 {
+				get(peers[0], fmt.Sprintf("/items/%d", i))
+			}
+
+			assert.Equal(t, 10, purge(target), target)
+			for _, p := range peers {
+				p.cache.Range(func(key string, _ cache.Entry) bool {
+					t.Errorf("%s still cached by %s after %s", key, p.cluster.Self, target)
+					return true
+				})
+			}
+		}
+
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+		var stats cacheStats
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+		assert.NotNil(t, stats.Bytes, "the local cache's usage is reported")
+	})
+
+	t.Run("Invalidation reaches the owner", func(t *testing.T) {
+		peers := newPeers(t)
+
+		for i := range 10 {
+			get(peers[0], fmt.Sprintf("/items/%d", i))
+		}
+
+		for i := range 10 {
+			w := httptest.NewRecorder()
+			peers[0].cluster.Handler(peers[0].handler).ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/items/%d", i), nil))
+			require.Equal(t, http.StatusOK, w.Code)
+		}
+
+		for _, p := range peers {
+			p.cache.Range(func(key string, _ cache.Entry) bool {
+				t.Errorf("%s still cached by %s", key, p.cluster.Self)
+				return true
+			})
+		}
+	})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Nelwhix/cachefik/internal/cache"
+)
+
+// flight is an upstream fetch for a cache key that concurrent requests for
+// the same key can wait on instead of going upstream themselves.
+type flight struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	ready     chan struct{}
+	finished  chan struct{}
+	published bool
+	shared    bool
+	pending   bool
+	status    int
+	header    http.Header
+	reqHeader http.Header
+	body      []byte
+	done      bool
+	failed    bool
+}
+
+func newFlight(reqHeader http.Header) *flight {
+	f := &flight{
+		ready:     make(chan struct{}),
+		finished:  make(chan struct{}),
+		reqHeader: reqHeader,
+	}
+	f.cond = sync.NewCond(&f.mu)
+
+	return f
+}
+
+// publish makes the response status and header available to waiters, or
+// tells them to go upstream on their own when the response is not shared.
+// Pending responses aren't shared but may be cached, so waiters wait for the
+// flight to finish and look up the cache again. A flight that ends without
+// being published is not shared.
+func (f *flight) publish(status int, header http.Header, shared, pending bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.published {
+		return
+	}
+
+	f.status = status
+	f.header = header
+	f.shared = shared
+	f.pending = pending
+	f.published = true
+	close(f.ready)
+}
+
+func (f *flight) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.body = append(f.body, p...)
+	f.cond.Broadcast()
+
+	return len(p), nil
+}
+
+func (f *flight) Bytes() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.body
+}
+
+// abort marks the body as incomplete, e.g. when it turned out too large to
+// cache or the upstream stream failed.
+func (f *flight) abort() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failed = true
+	f.cond.Broadcast()
+}
+
+func (f *flight) finish() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.published {
+		f.published = true
+		close(f.ready)
+	}
+
+	f.done = true
+	f.cond.Broadcast()
+	close(f.finished)
+}
+
+// next blocks until body bytes past offset are available or the flight ends.
+func (f *flight) next(offset int) ([]byte, bool, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.body) <= offset && !f.done && !f.failed {
+		f.cond.Wait()
+	}
+
+	return f.body[offset:], f.done, f.failed
+}
+
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// join returns the flight in progress for the key, or starts a new one and
+// reports the caller as its leader.
+func (g *flightGroup) join(key string, reqHeader http.Header) (*flight, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		return f, false
+	}
+
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+
+	f := newFlight(reqHeader)
+	g.flights[key] = f
+
+	return f, true
+}
+
+func (g *flightGroup) done(key string, f *flight) {
+	g.mu.Lock()
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+	g.mu.Unlock()
+
+	f.finish()
+}
+
+// serveFromFlight streams the leader's response as it arrives. It returns
+// false when the response can't be shared with this request, which should
+// then be served on its own.
+func (p *Proxy) serveFromFlight(w http.ResponseWriter, r *http.Request, f *flight, logger *slog.Logger) bool {
+	timer := time.NewTimer(p.CoalesceTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-f.ready:
+	case <-timer.C:
+		logger.Debug("coalesced request timed out")
+		return false
+	}
+
+	f.mu.Lock()
+	shared, pending, status, header := f.shared, f.pending, f.status, f.header
+	f.mu.Unlock()
+
+	if !shared && !pending {
+		return false
+	}
+
+	variant := cache.Entry{
+		Header:        header,
+		RequestHeader: cache.SelectingHeader(header, f.reqHeader),
+	}
+	if !variant.Matches(r.Header) || !cache.CanServe(r.Header, header) {
+		return false
+	}
+
+	if pending {
+		select {
+		case <-f.finished:
+		case <-timer.C:
+			logger.Debug("coalesced request timed out")
+		}
+		return false
+	}
+
+	chunk, done, failed := f.next(0)
+	if failed && len(chunk) == 0 {
+		return false
+	}
+
+	copyHeaders(w.Header(), header)
+	removeHopByHopHeaders(w.Header())
+	cache.RemoveTagHeaders(w.Header())
+	w.Header().Set("X-Cache", "COALESCED")
+	w.WriteHeader(status)
+
+	offset := 0
+	for {
+		if len(chunk) > 0 {
+			if _, err := w.Write(chunk); err != nil {
+				logger.Error("streaming failed", "error", err)
+				return true
+			}
+			offset += len(chunk)
+		}
+
+		if failed {
+			// Headers are already sent, resetting the connection is the only
+			// way to tell the client its body is incomplete
+			logger.Error("coalesced upstream response failed")
+			panic(http.ErrAbortHandler)
+		}
+
+		if done {
+			return true
+		}
+
+		chunk, done, failed = f.next(offset)
+	}
+}
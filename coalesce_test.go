@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Nelwhix/cachefik/internal/cache"
+	"github.com/Nelwhix/cachefik/internal/provider/docker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestCoalescing(t *testing.T) {
+	newProxy := func(upstream string, timeout time.Duration) *Proxy {
+		return &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: upstream},
+			},
+			Client:          &http.Client{},
+			Cache:           cache.NewMemoryCache(0),
+			MaxCacheSize:    1024 * 1024,
+			CoalesceTimeout: timeout,
+		}
+	}
+
+	serveConcurrently := func(p *Proxy, n int, path string, header http.Header) []*httptest.ResponseRecorder {
+		recorders := make([]*httptest.ResponseRecorder, n)
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				for k, vv := range header {
+					req.Header[k] = vv
+				}
+				recorders[i] = httptest.NewRecorder()
+				p.ServeHTTP(recorders[i], req)
+			}()
+		}
+		wg.Wait()
+
+		return recorders
+	}
+
+	t.Run("Single upstream fetch", func(t *testing.T) {
+		var upstreamCalls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Content-Length", "12")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("cold "))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("content"))
+		}))
+		defer backend.Close()
+
+		p := newProxy(backend.URL, time.Second)
+		recorders := serveConcurrently(p, 20, "/cold", nil)
+
+		assert.Equal(t, int32(1), upstreamCalls.Load())
+
+		statuses := map[string]int{}
+		for _, w := range recorders {
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "cold content", w.Body.String())
+			statuses[w.Header().Get("X-Cache")]++
+		}
+		assert.Equal(t, 1, statuses["MISS"])
+		assert.Equal(t, 19, statuses["COALESCED"]+statuses["HIT"])
+	})
+
+	t.Run("Uncacheable response falls back", func(t *testing.T) {
+		var upstreamCalls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("personal"))
+		}))
+		defer backend.Close()
+
+		p := newProxy(backend.URL, time.Second)
+		recorders := serveConcurrently(p, 5, "/no-store", nil)
+
+		assert.Equal(t, int32(5), upstreamCalls.Load())
+		for _, w := range recorders {
+			assert.Equal(t, "BYPASS", w.Header().Get("X-Cache"))
+			assert.Equal(t, "personal", w.Body.String())
+		}
+	})
+
+	t.Run("Different variant falls back", func(t *testing.T) {
+		var upstreamCalls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Vary", "Accept-Language")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("lang=" + r.Header.Get("Accept-Language")))
+		}))
+		defer backend.Close()
+
+		p := newProxy(backend.URL, time.Second)
+
+		var english, french []*httptest.ResponseRecorder
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			english = serveConcurrently(p, 3, "/vary", http.Header{"Accept-Language": []string{"en"}})
+		}()
+		go func() {
+			defer wg.Done()
+			french = serveConcurrently(p, 3, "/vary", http.Header{"Accept-Language": []string{"fr"}})
+		}()
+		wg.Wait()
+
+		for _, w := range english {
+			assert.Equal(t, "lang=en", w.Body.String())
+		}
+		for _, w := range french {
+			assert.Equal(t, "lang=fr", w.Body.String())
+		}
+		assert.LessOrEqual(t, upstreamCalls.Load(), int32(6))
+	})
+
+	// getConcurrently requests path n times at once through a proxy server,
+	// returning the bodies read and the read errors
+	getConcurrently := func(p *Proxy, n int, path string) ([][]byte, []error) {
+		server := httptest.NewServer(p)
+		defer server.Close()
+
+		bodies := make([][]byte, n)
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Go(func() {
+				resp, err := http.Get(server.URL + path)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				defer resp.Body.Close()
+				bodies[i], errs[i] = io.ReadAll(resp.Body)
+			})
+		}
+		wg.Wait()
+
+		return bodies, errs
+	}
+
+	t.Run("Chunked body larger than the cache limit", func(t *testing.T) {
+		body := bytes.Repeat([]byte("x"), 5000)
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Cache-Control", "max-age=60")
+			for chunk := range slices.Chunk(body, 500) {
+				w.Write(chunk)
+				w.(http.Flusher).Flush()
+			}
+		}))
+		defer backend.Close()
+
+		p := newProxy(backend.URL, time.Second)
+		p.MaxCacheSize = 1000
+
+		bodies, errs := getConcurrently(p, 5, "/chunked")
+		for i := range bodies {
+			assert.NoError(t, errs[i])
+			assert.Equal(t, body, bodies[i])
+		}
+	})
+
+	t.Run("Chunked body within the cache limit", func(t *testing.T) {
+		var upstreamCalls atomic.Int32
+		body := bytes.Repeat([]byte("x"), 5000)
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Cache-Control", "max-age=60")
+			for chunk := range slices.Chunk(body, 500) {
+				w.Write(chunk)
+				w.(http.Flusher).Flush()
+				time.Sleep(10 * time.Millisecond)
+			}
+		}))
+		defer backend.Close()
+
+		p := newProxy(backend.URL, time.Second)
+		bodies, errs := getConcurrently(p, 20, "/chunked")
+		for i := range bodies {
+			assert.NoError(t, errs[i])
+			assert.Equal(t, body, bodies[i])
+		}
+		assert.Equal(t, int32(1), upstreamCalls.Load(), "waiters are served from the cache once the leader stored it")
+	})
+
+	t.Run("Leader's client goes away", func(t *testing.T) {
+		var upstreamCalls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Content-Length", "12")
+			w.Write([]byte("cold content"))
+		}))
+		defer backend.Close()
+
+		p := newProxy(backend.URL, time.Second)
+		leader := make(chan struct{})
+		go func() {
+			defer close(leader)
+			p.ServeHTTP(failingWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/cold", nil))
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		recorders := serveConcurrently(p, 5, "/cold", nil)
+		<-leader
+
+		assert.Equal(t, int32(1), upstreamCalls.Load())
+		for _, w := range recorders {
+			assert.Equal(t, "cold content", w.Body.String())
+		}
+		_, ok := p.Cache.Get("GET:http://example.com/cold?", nil)
+		assert.True(t, ok, "the body is still cached")
+	})
+
+	t.Run("Failed upstream resets waiters", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Content-Length", "2000")
+			w.Write(make([]byte, 1000))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+			panic(http.ErrAbortHandler)
+		}))
+		defer backend.Close()
+
+		p := newProxy(backend.URL, time.Second)
+		_, errs := getConcurrently(p, 5, "/broken")
+		for _, err := range errs {
+			assert.Error(t, err, "a truncated body must not end cleanly")
+		}
+	})
+
+	t.Run("Wait timeout", func(t *testing.T) {
+		var upstreamCalls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("slow"))
+		}))
+		defer backend.Close()
+
+		p := newProxy(backend.URL, 20*time.Millisecond)
+		recorders := serveConcurrently(p, 3, "/slow", nil)
+
+		assert.Equal(t, int32(3), upstreamCalls.Load())
+		for _, w := range recorders {
+			assert.Equal(t, "slow", w.Body.String())
+		}
+	})
+}
+
+// failingWriter is the response writer of a client that went away.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
@@ -0,0 +1,103 @@
+package cache
+
+// arcPolicy implements the Adaptive Replacement Cache, weighted by size.
+// Keys seen once live in t1 and keys seen again in t2. Evicted keys are
+// remembered in the ghost lists b1 and b2, and a miss on a ghost shifts
+// the target size of t1 towards the list that would have kept it.
+type arcPolicy struct {
+	t1, t2   *lruList
+	b1, b2   *lruList
+	maxBytes int64
+	// target is the number of bytes t1 should hold
+	target int64
+}
+
+func newARCPolicy(maxBytes int64) *arcPolicy {
+	return &arcPolicy{
+		t1:       newLRUList(),
+		t2:       newLRUList(),
+		b1:       newLRUList(),
+		b2:       newLRUList(),
+		maxBytes: maxBytes,
+	}
+}
+
+func (p *arcPolicy) Add(key string, size int64) {
+	switch {
+	case p.b1.contains(key):
+		p.b1.remove(key)
+		p.target = min(p.maxBytes, p.target+size*max(ratio(p.b2.bytes, p.b1.bytes), 1))
+		p.t2.pushFront(key, size)
+	case p.b2.contains(key):
+		p.b2.remove(key)
+		p.target = max(0, p.target-size*max(ratio(p.b1.bytes, p.b2.bytes), 1))
+		p.t2.pushFront(key, size)
+	default:
+		p.t1.pushFront(key, size)
+	}
+
+	p.trimGhosts()
+}
+
+func (p *arcPolicy) Update(key string, size int64) {
+	p.Resize(key, size)
+	p.Hit(key)
+}
+
+func (p *arcPolicy) Resize(key string, size int64) {
+	p.t1.resize(key, size)
+	p.t2.resize(key, size)
+}
+
+func (p *arcPolicy) Hit(key string) {
+	if size, ok := p.t1.remove(key); ok {
+		p.t2.pushFront(key, size)
+		return
+	}
+
+	p.t2.moveToFront(key)
+}
+
+func (p *arcPolicy) Miss(string) {}
+
+func (p *arcPolicy) Remove(key string) {
+	p.t1.remove(key)
+	p.t2.remove(key)
+}
+
+func (p *arcPolicy) Victim() (string, bool) {
+	if p.t1.len() > 0 && (p.t1.bytes > p.target || p.t2.len() == 0) {
+		key, size, _ := p.t1.removeBack()
+		p.b1.pushFront(key, size)
+		p.trimGhosts()
+		return key, true
+	}
+
+	key, size, ok := p.t2.removeBack()
+	if !ok {
+		return "", false
+	}
+
+	p.b2.pushFront(key, size)
+	p.trimGhosts()
+	return key, true
+}
+
+// trimGhosts bounds t1 and b1 to the cache size, and all lists to twice it.
+func (p *arcPolicy) trimGhosts() {
+	for p.b1.len() > 0 && p.t1.bytes+p.b1.bytes > p.maxBytes {
+		p.b1.removeBack()
+	}
+
+	for p.b2.len() > 0 && p.t1.bytes+p.t2.bytes+p.b1.bytes+p.b2.bytes > 2*p.maxBytes {
+		p.b2.removeBack()
+	}
+}
+
+func ratio(a, b int64) int64 {
+	if b == 0 {
+		return 1
+	}
+
+	return a / b
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Breaker is a circuit breaker for remote backends and peers. It opens
+// after threshold consecutive failures, turning them into a bypass, and
+// lets a single trial request through once cooldown has elapsed.
+type Breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: max(threshold, 1), cooldown: cooldown}
+}
+
+// Allow reports whether a request may be sent to the backend.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+
+	// Half open, the other requests wait for the trial's outcome
+	b.openUntil = now.Add(b.cooldown)
+	return true
+}
+
+// Record counts the outcome of an allowed request, it returns true when a
+// failure opens the circuit.
+func (b *Breaker) Record(err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		return false
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+
+	return b.failures == b.threshold
+}
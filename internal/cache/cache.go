@@ -2,6 +2,7 @@ package cache
 
 import (
 	"net/http"
+	"slices"
 	"time"
 )
 
@@ -10,13 +11,168 @@ type Entry struct {
 	Header     http.Header
 	Body       []byte
 	ExpiresAt  time.Time
+	// RequestHeader holds the request header fields selected by the
+	// response's Vary header when the entry was stored.
+	RequestHeader http.Header
+	// StaleWhileRevalidate is how long after ExpiresAt the entry may still be
+	// served while a background refresh is in progress.
+	StaleWhileRevalidate time.Duration
+	// StaleIfError is how long after ExpiresAt the entry may still be served
+	// when the upstream fails.
+	StaleIfError time.Duration
+	// MustRevalidate forbids serving the entry stale, whatever the stale
+	// windows say.
+	MustRevalidate bool
+	// RequestTime and ResponseTime are when the upstream request was sent and
+	// when its response was stored, used to compute the entry's Age.
+	RequestTime  time.Time
+	ResponseTime time.Time
+	// Tier is the cache tier the entry was found in, starting at 1, when it
+	// is served by a TieredCache. It is not stored.
+	Tier int
+}
+
+// NewEntry builds the entry stored for an upstream response to a request
+// carrying reqHeader and sent at requestTime.
+func NewEntry(resp *http.Response, reqHeader http.Header, body []byte, ttl time.Duration, requestTime time.Time) Entry {
+	now := time.Now()
+
+	header := StorableHeader(resp.Header)
+	if header.Get("Date") == "" {
+		header.Set("Date", now.UTC().Format(http.TimeFormat))
+	}
+
+	entry := Entry{
+		StatusCode:    resp.StatusCode,
+		Header:        header,
+		Body:          body,
+		ExpiresAt:     now.Add(ttl),
+		RequestHeader: SelectingHeader(header, reqHeader),
+		RequestTime:   requestTime,
+		ResponseTime:  now,
+	}
+	entry.applyCacheControl()
+
+	return entry
+}
+
+func (e *Entry) applyCacheControl() {
+	e.StaleWhileRevalidate = StaleWhileRevalidate(e.Header)
+	e.StaleIfError = StaleIfError(e.Header)
+	e.MustRevalidate = MustRevalidate(ParseCacheControl(e.Header))
 }
 
 func (e Entry) Expired() bool {
 	return time.Now().After(e.ExpiresAt)
 }
 
+func (e Entry) WithinStaleWhileRevalidate() bool {
+	return !e.MustRevalidate && time.Now().Before(e.ExpiresAt.Add(e.StaleWhileRevalidate))
+}
+
+// WithinStaleIfError reports whether the entry may be served in place of an
+// upstream error, using the larger of its own window and the given one.
+func (e Entry) WithinStaleIfError(window time.Duration) bool {
+	return !e.MustRevalidate && time.Now().Before(e.ExpiresAt.Add(max(e.StaleIfError, window)))
+}
+
+// Discardable reports whether the entry has expired and can neither be
+// revalidated nor served stale anymore.
+func (e Entry) Discardable() bool {
+	return e.Expired() && !e.Revalidatable() && !e.WithinStaleWhileRevalidate() && !e.WithinStaleIfError(0)
+}
+
+// supersedes reports whether storing e replaces the stored variant. Variants
+// stored under a different Vary are superseded by the newer response, as are
+// those selected by the same request headers.
+func (e Entry) supersedes(stored Entry) bool {
+	return !slices.Equal(VaryFields(stored.Header), VaryFields(e.Header)) || stored.Matches(e.RequestHeader)
+}
+
+// Size approximates the memory held by the entry, its body plus headers.
+func (e Entry) Size() int {
+	return len(e.Body) + headerSize(e.Header) + headerSize(e.RequestHeader)
+}
+
+func headerSize(header http.Header) int {
+	size := 0
+	for k, vv := range header {
+		for _, v := range vv {
+			size += len(k) + len(v)
+		}
+	}
+
+	return size
+}
+
 type Cache interface {
-	Get(key string) (Entry, bool)
+	Get(key string, header http.Header) (Entry, bool)
 	Set(key string, entry Entry)
+	// Delete removes every variant stored under the key.
+	Delete(key string)
+	// Range calls fn for every stored variant until fn returns false.
+	// Expired entries that are still retained are included.
+	Range(fn func(key string, entry Entry) bool)
+}
+
+// MetadataRanger is implemented by caches that can iterate over their
+// entries without loading the bodies, e.g. from disk.
+type MetadataRanger interface {
+	// RangeMetadata is Range with entries lacking their body, size being the
+	// Size of the complete entry.
+	RangeMetadata(fn func(key string, entry Entry, size int) bool)
+}
+
+// RangeMetadata iterates over the entries of c like Range, without their
+// bodies when the cache can skip loading them.
+func RangeMetadata(c Cache, fn func(key string, entry Entry, size int) bool) {
+	if r, ok := c.(MetadataRanger); ok {
+		r.RangeMetadata(fn)
+		return
+	}
+
+	c.Range(func(key string, entry Entry) bool {
+		return fn(key, entry, entry.Size())
+	})
+}
+
+// Lister is implemented by caches whose Range may not visit their entries,
+// e.g. because the store can't list its keys.
+type Lister interface {
+	CanList() bool
+}
+
+// CanList reports whether Range visits every entry of c.
+func CanList(c Cache) bool {
+	if l, ok := c.(Lister); ok {
+		return l.CanList()
+	}
+
+	return true
+}
+
+// VariantLister is implemented by caches that can look up every variant of
+// a key without iterating over the whole cache.
+type VariantLister interface {
+	// Variants returns the variants stored under key, including the expired
+	// ones still retained.
+	Variants(key string) []Entry
+}
+
+// Variants returns the variants stored under key, through the cache's own
+// lookup when it has one.
+func Variants(c Cache, key string) []Entry {
+	if l, ok := c.(VariantLister); ok {
+		return l.Variants(key)
+	}
+
+	var variants []Entry
+	c.Range(func(k string, entry Entry) bool {
+		if k == key {
+			variants = append(variants, entry)
+		}
+		return true
+	})
+
+	return variants
 }
@@ -0,0 +1,119 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDeltaSeconds is the value used for delta-seconds that overflow, see
+// RFC 9111 section 1.2.2.
+const maxDeltaSeconds = 2147483648
+
+// CacheControl holds the directives of Cache-Control header fields, keyed by
+// lowercase directive name. Directives without an argument map to "".
+type CacheControl map[string]string
+
+// ParseCacheControl parses every Cache-Control field line of the header as
+// described in RFC 9111 section 5.2. Unknown extensions are kept, quoted
+// arguments are unescaped and only the first occurrence of a directive is
+// retained.
+func ParseCacheControl(header http.Header) CacheControl {
+	cc := make(CacheControl)
+	for _, value := range header.Values("Cache-Control") {
+		for len(value) > 0 {
+			var name, arg string
+			name, arg, value = nextDirective(value)
+			if name == "" {
+				continue
+			}
+
+			if _, ok := cc[name]; !ok {
+				cc[name] = arg
+			}
+		}
+	}
+
+	return cc
+}
+
+func (cc CacheControl) Has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// Seconds returns the delta-seconds argument of a directive. An invalid
+// argument is reported as present with a zero duration, so that the
+// response is treated as stale.
+func (cc CacheControl) Seconds(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+
+	if value == "" || strings.TrimLeft(value, "0123456789") != "" {
+		return 0, true
+	}
+
+	secs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || secs > maxDeltaSeconds {
+		secs = maxDeltaSeconds
+	}
+
+	return time.Duration(secs) * time.Second, true
+}
+
+// Fields returns the header field names listed in the qualified form of a
+// directive, such as private="Set-Cookie".
+func (cc CacheControl) Fields(name string) []string {
+	var fields []string
+	for _, field := range strings.Split(cc[name], ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, http.CanonicalHeaderKey(field))
+		}
+	}
+
+	return fields
+}
+
+// nextDirective consumes one directive from a Cache-Control value and
+// returns it along with the rest of the value.
+func nextDirective(value string) (string, string, string) {
+	value = strings.TrimLeft(value, " \t,")
+
+	end := strings.IndexAny(value, "=,")
+	if end < 0 {
+		return strings.ToLower(strings.TrimSpace(value)), "", ""
+	}
+
+	name := strings.ToLower(strings.TrimSpace(value[:end]))
+	if value[end] == ',' {
+		return name, "", value[end+1:]
+	}
+
+	value = strings.TrimLeft(value[end+1:], " \t")
+	if !strings.HasPrefix(value, `"`) {
+		arg, rest, _ := strings.Cut(value, ",")
+		return name, strings.TrimSpace(arg), rest
+	}
+
+	var arg strings.Builder
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			if i+1 < len(value) {
+				i++
+				arg.WriteByte(value[i])
+			}
+		case '"':
+			_, rest, _ := strings.Cut(value[i+1:], ",")
+			return name, arg.String(), rest
+		default:
+			arg.WriteByte(value[i])
+		}
+	}
+
+	// Unterminated quoted string, keep what was read
+	return name, arg.String(), ""
+}
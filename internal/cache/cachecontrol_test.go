@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	testCases := []struct {
+		name     string
+		values   []string
+		expected CacheControl
+	}{
+		{
+			name:     "Empty",
+			values:   nil,
+			expected: CacheControl{},
+		},
+		{
+			name:   "Simple directives",
+			values: []string{"public, max-age=60"},
+			expected: CacheControl{
+				"public":  "",
+				"max-age": "60",
+			},
+		},
+		{
+			name:   "Case and whitespace",
+			values: []string{"  No-Cache ,MAX-AGE = 5 "},
+			expected: CacheControl{
+				"no-cache": "",
+				"max-age":  "5",
+			},
+		},
+		{
+			name:   "Quoted argument with commas",
+			values: []string{`private="Set-Cookie, X-User", max-age=60`},
+			expected: CacheControl{
+				"private": "Set-Cookie, X-User",
+				"max-age": "60",
+			},
+		},
+		{
+			// RFC 9111 section 5.2.3
+			name:   "Quoted extension",
+			values: []string{`private, community="UCI"`},
+			expected: CacheControl{
+				"private":   "",
+				"community": "UCI",
+			},
+		},
+		{
+			name:   "Escaped quote",
+			values: []string{`ext="a\"b", no-store`},
+			expected: CacheControl{
+				"ext":      `a"b`,
+				"no-store": "",
+			},
+		},
+		{
+			name:   "Multiple field lines keep the first occurrence",
+			values: []string{"max-age=60", "max-age=10, must-revalidate"},
+			expected: CacheControl{
+				"max-age":         "60",
+				"must-revalidate": "",
+			},
+		},
+		{
+			name:   "Empty list members",
+			values: []string{",, no-store ,"},
+			expected: CacheControl{
+				"no-store": "",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, v := range tc.values {
+				header.Add("Cache-Control", v)
+			}
+			assert.Equal(t, tc.expected, ParseCacheControl(header))
+		})
+	}
+}
+
+func TestCacheControlSeconds(t *testing.T) {
+	testCases := []struct {
+		name            string
+		cc              CacheControl
+		expected        time.Duration
+		expectedPresent bool
+	}{
+		{
+			name:            "Absent",
+			cc:              CacheControl{},
+			expected:        0,
+			expectedPresent: false,
+		},
+		{
+			name:            "Valid",
+			cc:              CacheControl{"max-age": "60"},
+			expected:        60 * time.Second,
+			expectedPresent: true,
+		},
+		{
+			name:            "Negative",
+			cc:              CacheControl{"max-age": "-1"},
+			expected:        0,
+			expectedPresent: true,
+		},
+		{
+			name:            "Missing argument",
+			cc:              CacheControl{"max-age": ""},
+			expected:        0,
+			expectedPresent: true,
+		},
+		{
+			name:            "Overflow",
+			cc:              CacheControl{"max-age": "99999999999999999999"},
+			expected:        maxDeltaSeconds * time.Second,
+			expectedPresent: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, present := tc.cc.Seconds("max-age")
+			assert.Equal(t, tc.expected, got)
+			assert.Equal(t, tc.expectedPresent, present)
+		})
+	}
+}
+
+func TestCacheControlFields(t *testing.T) {
+	cc := CacheControl{
+		"private":  "set-cookie, X-User",
+		"no-cache": "",
+	}
+
+	assert.Equal(t, []string{"Set-Cookie", "X-User"}, cc.Fields("private"))
+	assert.Empty(t, cc.Fields("no-cache"))
+	assert.Empty(t, cc.Fields("missing"))
+}
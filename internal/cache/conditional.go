@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NotModified evaluates the client's If-None-Match / If-Modified-Since
+// preconditions against a cached entry, as described in RFC 9110 section 13.2.2.
+func NotModified(r *http.Request, entry Entry) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if entry.StatusCode < 200 || entry.StatusCode > 299 {
+		return false
+	}
+
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		etag := entry.Header.Get("ETag")
+		for _, value := range inm {
+			for _, tag := range parseETags(value) {
+				if tag == "*" || (etag != "" && weakMatch(tag, etag)) {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(entry.Header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// parseETags splits a list of entity tags, keeping commas that appear inside
+// the quoted opaque tag.
+func parseETags(value string) []string {
+	var tags []string
+	for {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			return tags
+		}
+
+		if value[0] == '*' {
+			tags = append(tags, "*")
+			value = value[1:]
+			continue
+		}
+
+		start := 0
+		if strings.HasPrefix(value, "W/") {
+			start = 2
+		}
+		if len(value) <= start || value[start] != '"' {
+			// Malformed tag, skip to the next list member
+			_, value, _ = strings.Cut(value, ",")
+			continue
+		}
+
+		end := strings.IndexByte(value[start+1:], '"')
+		if end < 0 {
+			return tags
+		}
+
+		end += start + 2
+		tags = append(tags, value[:end])
+		value = value[end:]
+	}
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotModified(t *testing.T) {
+	entry := Entry{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Etag":          []string{`W/"v1"`},
+			"Last-Modified": []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		method   string
+		headers  http.Header
+		entry    *Entry
+		expected bool
+	}{
+		{
+			name:     "No preconditions",
+			method:   http.MethodGet,
+			expected: false,
+		},
+		{
+			name:   "If-None-Match strong tag matches weak ETag",
+			method: http.MethodGet,
+			headers: http.Header{
+				"If-None-Match": []string{`"v1"`},
+			},
+			expected: true,
+		},
+		{
+			name:   "If-None-Match list",
+			method: http.MethodGet,
+			headers: http.Header{
+				"If-None-Match": []string{`"a,b", W/"v1"`},
+			},
+			expected: true,
+		},
+		{
+			name:   "If-None-Match mismatch",
+			method: http.MethodGet,
+			headers: http.Header{
+				"If-None-Match": []string{`"v2"`},
+			},
+			expected: false,
+		},
+		{
+			name:   "If-None-Match wildcard",
+			method: http.MethodGet,
+			headers: http.Header{
+				"If-None-Match": []string{"*"},
+			},
+			expected: true,
+		},
+		{
+			name:   "If-None-Match takes precedence over If-Modified-Since",
+			method: http.MethodGet,
+			headers: http.Header{
+				"If-None-Match":     []string{`"v2"`},
+				"If-Modified-Since": []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+			},
+			expected: false,
+		},
+		{
+			name:   "If-Modified-Since not modified",
+			method: http.MethodGet,
+			headers: http.Header{
+				"If-Modified-Since": []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+			},
+			expected: true,
+		},
+		{
+			name:   "If-Modified-Since modified",
+			method: http.MethodGet,
+			headers: http.Header{
+				"If-Modified-Since": []string{"Tue, 20 Oct 2015 07:28:00 GMT"},
+			},
+			expected: false,
+		},
+		{
+			name:   "If-Modified-Since invalid date",
+			method: http.MethodGet,
+			headers: http.Header{
+				"If-Modified-Since": []string{"yesterday"},
+			},
+			expected: false,
+		},
+		{
+			name:   "Non-success entry",
+			method: http.MethodGet,
+			headers: http.Header{
+				"If-None-Match": []string{"*"},
+			},
+			entry:    &Entry{StatusCode: http.StatusNotFound},
+			expected: false,
+		},
+		{
+			name:   "Unsafe method",
+			method: http.MethodPost,
+			headers: http.Header{
+				"If-None-Match": []string{`"v1"`},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _ := http.NewRequest(tc.method, "http://example.com", nil)
+			for k, vv := range tc.headers {
+				for _, v := range vv {
+					r.Header.Add(k, v)
+				}
+			}
+
+			e := entry
+			if tc.entry != nil {
+				e = *tc.entry
+			}
+			assert.Equal(t, tc.expected, NotModified(r, e))
+		})
+	}
+}
+
+func TestParseETags(t *testing.T) {
+	assert.Equal(t, []string{`"a"`, `W/"b"`, "*"}, parseETags(`"a", W/"b" ,*`))
+	assert.Equal(t, []string{`"a,b"`}, parseETags(`"a,b"`))
+	assert.Equal(t, []string{`"c"`}, parseETags(`bogus, "c"`))
+	assert.Empty(t, parseETags(`"unterminated`))
+}
@@ -1,52 +1,139 @@
 package cache
 
 import (
+	"fmt"
 	"net/http"
-	"strconv"
-	"strings"
+	"slices"
 	"time"
 )
 
 const defaultTTL = 30 * time.Second
 
 func CanCacheRequest(r *http.Request) bool {
-	if r.Method != http.MethodGet {
+	// HEAD requests are answered from the GET entry, see Key
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		return false
 	}
 
-	if r.Header.Get("Authorization") != "" {
+	if ParseCacheControl(r.Header).Has("no-store") {
 		return false
 	}
 
-	cc := r.Header.Get("Cache-Control")
-	if strings.Contains(cc, "no-store") {
-		return false
+	return true
+}
+
+// CanServe reports whether a stored response may be used for a request.
+// Requests carrying credentials only reuse responses that are explicitly
+// shareable, see RFC 9111 section 3.5.
+func CanServe(reqHeader, respHeader http.Header) bool {
+	if reqHeader.Get("Authorization") == "" {
+		return true
 	}
 
-	return true
+	return sharedWithAuthorization(ParseCacheControl(respHeader))
+}
+
+// RequiresRevalidation reports whether the client asked for stored
+// responses to be validated with the upstream before being reused.
+func RequiresRevalidation(reqHeader http.Header) bool {
+	if _, ok := reqHeader["Cache-Control"]; !ok {
+		return reqHeader.Get("Pragma") == "no-cache"
+	}
+
+	return ParseCacheControl(reqHeader).Has("no-cache")
 }
 
 func CanCacheResponse(resp *http.Response) (time.Duration, bool) {
-	cc := resp.Header.Get("Cache-Control")
+	return DefaultPolicy.CanCacheResponse(resp)
+}
 
-	if strings.Contains(cc, "no-store") || strings.Contains(cc, "private") {
-		return 0, false
+func (p Policy) CanCacheResponse(resp *http.Response) (time.Duration, bool) {
+	ttl, reason := p.CheckResponse(resp)
+	return ttl, reason == ""
+}
+
+// CheckResponse returns the TTL of a cacheable response, or the reason it
+// can't be stored.
+func (p Policy) CheckResponse(resp *http.Response) (time.Duration, string) {
+	// Server errors must not replace an entry that could be served stale
+	if resp.StatusCode == http.StatusNotModified || resp.StatusCode >= http.StatusInternalServerError {
+		return 0, fmt.Sprintf("status %d", resp.StatusCode)
 	}
 
-	for _, part := range strings.Split(cc, ",") {
-		part = strings.TrimSpace(part)
-		value, ok := strings.CutPrefix(part, "max-age=")
-		if !ok {
-			continue
-		}
+	// Partial content is never stored in place of the full object
+	if resp.StatusCode == http.StatusPartialContent {
+		return 0, "partial content"
+	}
+
+	cc := ParseCacheControl(resp.Header)
+
+	if cc.Has("no-store") {
+		return 0, "no-store"
+	}
+
+	// The qualified form only excludes the listed fields, see StorableHeader
+	if cc.Has("private") && len(cc.Fields("private")) == 0 {
+		return 0, "private"
+	}
 
-		secs, err := strconv.Atoi(value)
-		if err != nil || secs <= 0 {
-			return 0, false
+	if slices.Contains(VaryFields(resp.Header), "*") {
+		return 0, "Vary: *"
+	}
+
+	if resp.Request != nil && resp.Request.Header.Get("Authorization") != "" && !sharedWithAuthorization(cc) {
+		return 0, "authorized request"
+	}
+
+	now := time.Now()
+	lifetime, ok := FreshnessLifetime(resp.Header, cc, now)
+	if !ok {
+		if lifetime, ok = p.defaultLifetime(resp, cc, now); !ok {
+			return 0, "no freshness lifetime"
 		}
+	}
+
+	ttl := p.capLifetime(lifetime) - InitialAge(resp.Header, now)
+	if cc.Has("no-cache") && len(cc.Fields("no-cache")) == 0 {
+		ttl = 0
+	}
+
+	// Stale on arrival, only worth storing when it can be revalidated
+	if ttl <= 0 && !(Entry{Header: resp.Header}).Revalidatable() {
+		return 0, "stale without validator"
+	}
 
-		return time.Duration(secs) * time.Second, true
+	return max(ttl, 0), ""
+}
+
+// StorableHeader returns a copy of the response header without the fields
+// named by the qualified private and no-cache directives, which a shared
+// cache must not reuse.
+func StorableHeader(header http.Header) http.Header {
+	stored := header.Clone()
+
+	cc := ParseCacheControl(header)
+	for _, field := range append(cc.Fields("private"), cc.Fields("no-cache")...) {
+		stored.Del(field)
 	}
 
-	return defaultTTL, true
+	return stored
+}
+
+// StaleWhileRevalidate returns the window after expiry during which a
+// response may be served stale while it is refreshed in the background.
+func StaleWhileRevalidate(header http.Header) time.Duration {
+	d, _ := ParseCacheControl(header).Seconds("stale-while-revalidate")
+	return d
+}
+
+// StaleIfError returns the window after expiry during which a response may
+// be served stale when the upstream fails. It applies to both request and
+// response headers.
+func StaleIfError(header http.Header) time.Duration {
+	d, _ := ParseCacheControl(header).Seconds("stale-if-error")
+	return d
+}
+
+func sharedWithAuthorization(cc CacheControl) bool {
+	return cc.Has("public") || cc.Has("s-maxage") || cc.Has("must-revalidate")
 }
@@ -20,18 +20,24 @@ func TestCanCacheRequest(t *testing.T) {
 			method:   http.MethodGet,
 			expected: true,
 		},
+		{
+			name:     "HEAD request",
+			method:   http.MethodHead,
+			expected: true,
+		},
 		{
 			name:     "POST request",
 			method:   http.MethodPost,
 			expected: false,
 		},
 		{
+			// Whether the response may be stored is decided by CanCacheResponse
 			name:   "Authorization header",
 			method: http.MethodGet,
 			headers: http.Header{
 				"Authorization": []string{"Bearer token"},
 			},
-			expected: false,
+			expected: true,
 		},
 		{
 			name:   "Cache-Control no-store",
@@ -41,6 +47,14 @@ func TestCanCacheRequest(t *testing.T) {
 			},
 			expected: false,
 		},
+		{
+			name:   "no-store inside a quoted extension",
+			method: http.MethodGet,
+			headers: http.Header{
+				"Cache-Control": []string{`community="no-store"`},
+			},
+			expected: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -60,7 +74,9 @@ func TestCanCacheRequest(t *testing.T) {
 func TestCanCacheResponse(t *testing.T) {
 	testCases := []struct {
 		name        string
+		status      int
 		headers     http.Header
+		authorized  bool
 		expectedTTL time.Duration
 		expectedOk  bool
 	}{
@@ -94,16 +110,311 @@ func TestCanCacheResponse(t *testing.T) {
 			expectedTTL: 0,
 			expectedOk:  false,
 		},
+		{
+			name: "Vary wildcard",
+			headers: http.Header{
+				"Vary": []string{"*"},
+			},
+			expectedTTL: 0,
+			expectedOk:  false,
+		},
+		{
+			name: "s-maxage overrides max-age",
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=60, s-maxage=120"},
+			},
+			expectedTTL: 120 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name: "max-age overrides Expires",
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Date":          []string{"Thu, 01 Dec 1994 16:00:00 GMT"},
+				"Expires":       []string{"Thu, 01 Dec 1994 17:00:00 GMT"},
+			},
+			expectedTTL: 0,
+			expectedOk:  false,
+		},
+		{
+			name: "Quoted max-age",
+			headers: http.Header{
+				"Cache-Control": []string{`max-age="60"`},
+			},
+			expectedTTL: 60 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name: "Directive names are case-insensitive",
+			headers: http.Header{
+				"Cache-Control": []string{"Max-Age=60"},
+			},
+			expectedTTL: 60 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name: "Invalid max-age",
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=soon"},
+			},
+			expectedTTL: 0,
+			expectedOk:  false,
+		},
+		{
+			name: "no-store inside a quoted extension",
+			headers: http.Header{
+				"Cache-Control": []string{`max-age=60, community="UCI, no-store"`},
+			},
+			expectedTTL: 60 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name: "Qualified private",
+			headers: http.Header{
+				"Cache-Control": []string{`private="Set-Cookie", max-age=60`},
+			},
+			expectedTTL: 60 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name: "no-cache with validator",
+			headers: http.Header{
+				"Cache-Control": []string{"no-cache"},
+				"Etag":          []string{`"v1"`},
+			},
+			expectedTTL: 0,
+			expectedOk:  true,
+		},
+		{
+			name: "no-cache without validator",
+			headers: http.Header{
+				"Cache-Control": []string{"no-cache"},
+			},
+			expectedTTL: 0,
+			expectedOk:  false,
+		},
+		{
+			name: "Age is subtracted",
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Age":           []string{"20"},
+			},
+			expectedTTL: 40 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name: "Age beyond lifetime",
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Age":           []string{"90"},
+			},
+			expectedTTL: 0,
+			expectedOk:  false,
+		},
+		{
+			name:   "Not heuristically cacheable status",
+			status: http.StatusFound,
+			headers: http.Header{
+				"Location": []string{"/elsewhere"},
+			},
+			expectedTTL: 0,
+			expectedOk:  false,
+		},
+		{
+			name:   "Explicit freshness on any status",
+			status: http.StatusFound,
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+			},
+			expectedTTL: 60 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name:   "public on any status",
+			status: http.StatusFound,
+			headers: http.Header{
+				"Cache-Control": []string{"public"},
+			},
+			expectedTTL: defaultTTL,
+			expectedOk:  true,
+		},
+		{
+			name:        "Authorization",
+			headers:     http.Header{},
+			authorized:  true,
+			expectedTTL: 0,
+			expectedOk:  false,
+		},
+		{
+			name: "Authorization with public",
+			headers: http.Header{
+				"Cache-Control": []string{"public, max-age=60"},
+			},
+			authorized:  true,
+			expectedTTL: 60 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name: "Authorization with s-maxage",
+			headers: http.Header{
+				"Cache-Control": []string{"s-maxage=60"},
+			},
+			authorized:  true,
+			expectedTTL: 60 * time.Second,
+			expectedOk:  true,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
+			status := tc.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
 			resp := &http.Response{
-				Header: tc.headers,
+				StatusCode: status,
+				Header:     tc.headers,
+			}
+			if tc.authorized {
+				resp.Request, _ = http.NewRequest(http.MethodGet, "http://example.com", nil)
+				resp.Request.Header.Set("Authorization", "Bearer token")
 			}
 			gotTTL, gotOk := CanCacheResponse(resp)
 			assert.Equal(t, tc.expectedTTL, gotTTL)
 			assert.Equal(t, tc.expectedOk, gotOk)
 		})
 	}
+
+	t.Run("Not Modified", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: http.StatusNotModified,
+			Header:     http.Header{},
+		}
+		_, ok := CanCacheResponse(resp)
+		assert.False(t, ok)
+	})
+
+	t.Run("Partial content", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Header:     http.Header{"Cache-Control": []string{"max-age=60"}},
+		}
+		_, ok := CanCacheResponse(resp)
+		assert.False(t, ok)
+	})
+
+	t.Run("Server error", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Header:     http.Header{},
+		}
+		_, ok := CanCacheResponse(resp)
+		assert.False(t, ok)
+	})
+}
+
+func TestCheckResponse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		cc       string
+		expected string
+	}{
+		{name: "Cacheable", cc: "max-age=60", expected: ""},
+		{name: "Server error", status: http.StatusBadGateway, expected: "status 502"},
+		{name: "no-store", cc: "no-store", expected: "no-store"},
+		{name: "private", cc: "private, max-age=60", expected: "private"},
+		{name: "Stale on arrival", cc: "max-age=0", expected: "stale without validator"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status := tc.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			_, reason := DefaultPolicy.CheckResponse(&http.Response{
+				StatusCode: status,
+				Header:     http.Header{"Cache-Control": []string{tc.cc}},
+			})
+			assert.Equal(t, tc.expected, reason)
+		})
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cc       string
+		expected time.Duration
+	}{
+		{
+			name:     "Absent",
+			cc:       "max-age=60",
+			expected: 0,
+		},
+		{
+			name:     "Present",
+			cc:       "max-age=60, stale-while-revalidate=30",
+			expected: 30 * time.Second,
+		},
+		{
+			name:     "Invalid",
+			cc:       "stale-while-revalidate=soon",
+			expected: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{"Cache-Control": []string{tc.cc}}
+			assert.Equal(t, tc.expected, StaleWhileRevalidate(header))
+		})
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	header := http.Header{"Cache-Control": []string{"max-age=60, stale-if-error=300"}}
+	assert.Equal(t, 300*time.Second, StaleIfError(header))
+
+	header = http.Header{"Cache-Control": []string{"max-age=60"}}
+	assert.Equal(t, time.Duration(0), StaleIfError(header))
+}
+
+func TestCanServe(t *testing.T) {
+	anonymous := http.Header{}
+	authorized := http.Header{"Authorization": []string{"Bearer token"}}
+
+	assert.True(t, CanServe(anonymous, http.Header{}))
+	assert.False(t, CanServe(authorized, http.Header{}))
+	assert.True(t, CanServe(authorized, http.Header{"Cache-Control": []string{"public"}}))
+	assert.True(t, CanServe(authorized, http.Header{"Cache-Control": []string{"must-revalidate"}}))
+}
+
+func TestRequiresRevalidation(t *testing.T) {
+	assert.False(t, RequiresRevalidation(http.Header{}))
+	assert.True(t, RequiresRevalidation(http.Header{"Cache-Control": []string{"no-cache"}}))
+	assert.True(t, RequiresRevalidation(http.Header{"Pragma": []string{"no-cache"}}))
+	assert.False(t, RequiresRevalidation(http.Header{
+		"Cache-Control": []string{"max-stale"},
+		"Pragma":        []string{"no-cache"},
+	}))
+}
+
+func TestStorableHeader(t *testing.T) {
+	header := http.Header{
+		"Cache-Control": []string{`private="Set-Cookie", no-cache="X-Debug", max-age=60`},
+		"Set-Cookie":    []string{"session=1"},
+		"X-Debug":       []string{"trace"},
+		"Content-Type":  []string{"text/html"},
+	}
+
+	stored := StorableHeader(header)
+
+	assert.Empty(t, stored.Get("Set-Cookie"))
+	assert.Empty(t, stored.Get("X-Debug"))
+	assert.Equal(t, "text/html", stored.Get("Content-Type"))
+	assert.Equal(t, "session=1", header.Get("Set-Cookie"))
 }
@@ -0,0 +1,500 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	diskBodySuffix = ".body"
+	diskMetaSuffix = ".meta"
+	diskTempPrefix = ".tmp-"
+)
+
+// DiskCache stores every variant as a body file and a JSON metadata file
+// under a directory, so the cache survives restarts. Both files are written
+// to a temporary file and renamed, and the metadata is written last, so a
+// metadata file always describes a complete body. The index of the stored
+// variants is kept in memory and rebuilt from the metadata files on startup.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu     sync.Mutex
+	used   int64
+	items  map[string][]diskVariant
+	policy evictionPolicy
+	// seq makes the file names of every write unique, so a reader never
+	// sees a body replaced under its metadata
+	seq atomic.Uint64
+}
+
+type diskVariant struct {
+	// path is the file path without suffix
+	path string
+	meta diskMeta
+}
+
+// diskMeta is the stored entry without its body.
+type diskMeta struct {
+	Key                  string        `json:"key"`
+	StatusCode           int           `json:"status_code"`
+	Header               http.Header   `json:"header"`
+	BodySize             int64         `json:"body_size"`
+	ExpiresAt            time.Time     `json:"expires_at"`
+	RequestHeader        http.Header   `json:"request_header,omitempty"`
+	StaleWhileRevalidate time.Duration `json:"stale_while_revalidate,omitempty"`
+	StaleIfError         time.Duration `json:"stale_if_error,omitempty"`
+	MustRevalidate       bool          `json:"must_revalidate,omitempty"`
+	RequestTime          time.Time     `json:"request_time"`
+	ResponseTime         time.Time     `json:"response_time"`
+}
+
+func newDiskMeta(key string, entry Entry) diskMeta {
+	return diskMeta{
+		Key:                  key,
+		StatusCode:           entry.StatusCode,
+		Header:               entry.Header,
+		BodySize:             int64(len(entry.Body)),
+		ExpiresAt:            entry.ExpiresAt,
+		RequestHeader:        entry.RequestHeader,
+		StaleWhileRevalidate: entry.StaleWhileRevalidate,
+		StaleIfError:         entry.StaleIfError,
+		MustRevalidate:       entry.MustRevalidate,
+		RequestTime:          entry.RequestTime,
+		ResponseTime:         entry.ResponseTime,
+	}
+}
+
+// entry returns the stored entry, with the given body.
+func (m diskMeta) entry(body []byte) Entry {
+	return Entry{
+		StatusCode:           m.StatusCode,
+		Header:               m.Header,
+		Body:                 body,
+		ExpiresAt:            m.ExpiresAt,
+		RequestHeader:        m.RequestHeader,
+		StaleWhileRevalidate: m.StaleWhileRevalidate,
+		StaleIfError:         m.StaleIfError,
+		MustRevalidate:       m.MustRevalidate,
+		RequestTime:          m.RequestTime,
+		ResponseTime:         m.ResponseTime,
+	}
+}
+
+func (m diskMeta) size() int64 {
+	return int64(len(m.Key)+headerSize(m.Header)+headerSize(m.RequestHeader)) + m.BodySize
+}
+
+// NewDiskCache opens the cache stored in dir, creating it if needed, and
+// evicts the least recently used keys once the stored bodies and headers
+// exceed maxBytes. Zero or less means no limit.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		items:    make(map[string][]diskVariant),
+		policy:   newLRUPolicy(),
+	}
+
+	if err := c.recover(); err != nil {
+		return nil, fmt.Errorf("recovering cache index: %w", err)
+	}
+
+	return c, nil
+}
+
+// recover rebuilds the index from the metadata files, removing leftovers
+// of interrupted writes and variants that can't be served anymore.
+func (c *DiskCache) recover() error {
+	var variants []diskVariant
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		name := d.Name()
+		switch {
+		case strings.HasPrefix(name, diskTempPrefix):
+			os.Remove(path)
+		case strings.HasSuffix(name, diskMetaSuffix):
+			v, ok := readDiskVariant(strings.TrimSuffix(path, diskMetaSuffix))
+			if ok {
+				variants = append(variants, v)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The most recently stored variants are the most recently used
+	sort.Slice(variants, func(i, j int) bool {
+		return variants[i].meta.ResponseTime.Before(variants[j].meta.ResponseTime)
+	})
+
+	indexed := make(map[string]bool)
+	for _, v := range variants {
+		c.add(v)
+		indexed[v.path] = true
+	}
+
+	// Remove the bodies whose metadata was never written or was discarded
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(path, diskBodySuffix) && !indexed[strings.TrimSuffix(path, diskBodySuffix)] {
+			os.Remove(path)
+		}
+		return nil
+	})
+
+	c.evict()
+	return nil
+}
+
+// readDiskVariant reads a metadata file, removing it when it is corrupt,
+// its body is missing or it can't be served anymore.
+func readDiskVariant(path string) (diskVariant, bool) {
+	v := diskVariant{path: path}
+
+	data, err := os.ReadFile(path + diskMetaSuffix)
+	if err == nil {
+		err = json.Unmarshal(data, &v.meta)
+	}
+	if err == nil {
+		var info fs.FileInfo
+		if info, err = os.Stat(path + diskBodySuffix); err == nil && info.Size() != v.meta.BodySize {
+			err = errors.New("body size mismatch")
+		}
+	}
+
+	if err != nil || v.meta.entry(nil).Discardable() {
+		if err != nil {
+			slog.Warn("discarding disk cache entry", "path", path, "error", err)
+		}
+		os.Remove(path + diskMetaSuffix)
+		return v, false
+	}
+
+	return v, true
+}
+
+func (c *DiskCache) Get(key string, header http.Header) (Entry, bool) {
+	c.mu.Lock()
+	variants, ok := c.items[key]
+	if !ok {
+		c.policy.Miss(key)
+		c.mu.Unlock()
+		return Entry{}, false
+	}
+
+	var match *diskVariant
+	for i, v := range variants {
+		if entry := v.meta.entry(nil); !entry.Discardable() && entry.Matches(header) {
+			match = &variants[i]
+			break
+		}
+	}
+
+	if match == nil {
+		c.discard(key)
+		c.mu.Unlock()
+		return Entry{}, false
+	}
+
+	v := *match
+	c.policy.Hit(key)
+	c.mu.Unlock()
+
+	// A concurrent write or eviction may have removed the body meanwhile
+	body, err := os.ReadFile(v.path + diskBodySuffix)
+	if err != nil || int64(len(body)) != v.meta.BodySize {
+		return Entry{}, false
+	}
+
+	return v.meta.entry(body), true
+}
+
+func (c *DiskCache) Set(key string, entry Entry) {
+	meta := newDiskMeta(key, entry)
+	if c.maxBytes > 0 && meta.size() > c.maxBytes {
+		c.Delete(key)
+		return
+	}
+
+	v := diskVariant{path: c.path(key), meta: meta}
+	if err := c.write(v, entry.Body); err != nil {
+		slog.Error("disk cache write failed", "key", key, "error", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if variants, ok := c.items[key]; ok {
+		var removed []diskVariant
+		c.items[key] = slices.DeleteFunc(variants, func(old diskVariant) bool {
+			if entry.supersedes(old.meta.entry(nil)) {
+				removed = append(removed, old)
+				return true
+			}
+			return false
+		})
+		for _, old := range removed {
+			c.used -= old.meta.size()
+			removeDiskVariant(old)
+		}
+	}
+
+	c.add(v)
+	c.evict()
+}
+
+// path returns a new file path for a variant of key, without suffix. Files
+// are spread over subdirectories named after the key hash.
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+
+	return filepath.Join(c.dir, name[:2], fmt.Sprintf("%s-%d-%d", name, time.Now().UnixNano(), c.seq.Add(1)))
+}
+
+// write stores the body then the metadata of a variant.
+func (c *DiskCache) write(v diskVariant, body []byte) error {
+	meta, err := json.Marshal(v.meta)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(v.path), 0o755); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(v.path+diskBodySuffix, body); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(v.path+diskMetaSuffix, meta); err != nil {
+		os.Remove(v.path + diskBodySuffix)
+		return err
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file renamed over path, so path
+// never holds a partial write.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), diskTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// removeDiskVariant removes the metadata first, so a crash in between only
+// leaves an orphan body removed on the next startup.
+func removeDiskVariant(v diskVariant) {
+	os.Remove(v.path + diskMetaSuffix)
+	os.Remove(v.path + diskBodySuffix)
+}
+
+// add indexes a variant whose files are written. The caller holds the lock.
+func (c *DiskCache) add(v diskVariant) {
+	key := v.meta.Key
+	_, stored := c.items[key]
+	c.items[key] = append([]diskVariant{v}, c.items[key]...)
+	c.used += v.meta.size()
+
+	if stored {
+		c.policy.Update(key, c.keySize(key))
+	} else {
+		c.policy.Add(key, c.keySize(key))
+	}
+}
+
+func (c *DiskCache) keySize(key string) int64 {
+	var size int64
+	for _, v := range c.items[key] {
+		size += v.meta.size()
+	}
+
+	return size
+}
+
+// discard removes the variants of key that can't be served anymore. The
+// caller holds the lock.
+func (c *DiskCache) discard(key string) {
+	var removed []diskVariant
+	variants := slices.DeleteFunc(c.items[key], func(v diskVariant) bool {
+		if v.meta.entry(nil).Discardable() {
+			removed = append(removed, v)
+			return true
+		}
+		return false
+	})
+
+	for _, v := range removed {
+		c.used -= v.meta.size()
+		removeDiskVariant(v)
+	}
+
+	if len(variants) == 0 {
+		delete(c.items, key)
+		c.policy.Remove(key)
+		return
+	}
+
+	c.items[key] = variants
+	c.policy.Resize(key, c.keySize(key))
+}
+
+// evict removes the least recently used keys until the cache fits in its
+// budget. The caller holds the lock.
+func (c *DiskCache) evict() {
+	for c.maxBytes > 0 && c.used > c.maxBytes {
+		key, ok := c.policy.Victim()
+		if !ok {
+			return
+		}
+
+		c.removeKey(key)
+	}
+}
+
+// removeKey removes every variant of key from the index and the disk. The
+// caller holds the lock and has already removed key from the policy.
+func (c *DiskCache) removeKey(key string) {
+	for _, v := range c.items[key] {
+		c.used -= v.meta.size()
+		removeDiskVariant(v)
+	}
+	delete(c.items, key)
+}
+
+func (c *DiskCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.items[key]; ok {
+		c.removeKey(key)
+		c.policy.Remove(key)
+	}
+}
+
+// Variants reads the bodies of the variants of key only.
+func (c *DiskCache) Variants(key string) []Entry {
+	c.mu.Lock()
+	variants := slices.Clone(c.items[key])
+	c.mu.Unlock()
+
+	var entries []Entry
+	for _, v := range variants {
+		body, err := os.ReadFile(v.path + diskBodySuffix)
+		if err == nil {
+			entries = append(entries, v.meta.entry(body))
+		}
+	}
+
+	return entries
+}
+
+// Range reads the body of every variant, which is expensive on large caches.
+func (c *DiskCache) Range(fn func(key string, entry Entry) bool) {
+	c.mu.Lock()
+	var variants []diskVariant
+	for _, vv := range c.items {
+		variants = append(variants, vv...)
+	}
+	c.mu.Unlock()
+
+	for _, v := range variants {
+		body, err := os.ReadFile(v.path + diskBodySuffix)
+		if err != nil {
+			continue
+		}
+
+		if !fn(v.meta.Key, v.meta.entry(body)) {
+			return
+		}
+	}
+}
+
+// RangeMetadata only reads the index.
+func (c *DiskCache) RangeMetadata(fn func(key string, entry Entry, size int) bool) {
+	c.mu.Lock()
+	var variants []diskVariant
+	for _, vv := range c.items {
+		variants = append(variants, vv...)
+	}
+	c.mu.Unlock()
+
+	for _, v := range variants {
+		entry := v.meta.entry(nil)
+		if !fn(v.meta.Key, entry, entry.Size()+int(v.meta.BodySize)) {
+			return
+		}
+	}
+}
+
+// PurgeTag removes every key with a variant tagged with tag, without
+// reading the bodies.
+func (c *DiskCache) PurgeTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for key, variants := range c.items {
+		if slices.ContainsFunc(variants, func(v diskVariant) bool {
+			return slices.Contains(Tags(v.meta.Header), tag)
+		}) {
+			c.removeKey(key)
+			c.policy.Remove(key)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+// Usage returns the total size of the stored entries in bytes.
+func (c *DiskCache) Usage() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.used
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func diskFiles(t *testing.T, dir, pattern string) []string {
+	matches, err := filepath.Glob(filepath.Join(dir, "*", pattern))
+	require.NoError(t, err)
+	return matches
+}
+
+func TestDiskCache(t *testing.T) {
+	entry := Entry{
+		StatusCode:   http.StatusOK,
+		Header:       http.Header{"Content-Type": []string{"text/plain"}, "Surrogate-Key": []string{"home"}},
+		Body:         []byte("cached content"),
+		ExpiresAt:    time.Now().Add(1 * time.Hour).Round(0),
+		StaleIfError: 1 * time.Minute,
+		RequestTime:  time.Now().Add(-1 * time.Second).Round(0),
+		ResponseTime: time.Now().Round(0),
+	}
+
+	t.Run("Set and Get", func(t *testing.T) {
+		c, err := NewDiskCache(t.TempDir(), 0)
+		require.NoError(t, err)
+
+		c.Set("key", entry)
+		got, ok := c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, entry.Body, got.Body)
+		assert.Equal(t, entry.Header, got.Header)
+		assert.True(t, entry.ExpiresAt.Equal(got.ExpiresAt))
+		assert.Equal(t, entry.StaleIfError, got.StaleIfError)
+
+		_, ok = c.Get("missing", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("Replace and Delete", func(t *testing.T) {
+		dir := t.TempDir()
+		c, err := NewDiskCache(dir, 0)
+		require.NoError(t, err)
+
+		c.Set("key", entry)
+		replaced := entry
+		replaced.Body = []byte("new content")
+		c.Set("key", replaced)
+
+		got, _ := c.Get("key", nil)
+		assert.Equal(t, "new content", string(got.Body))
+		assert.Len(t, diskFiles(t, dir, "*.body"), 1)
+		assert.Equal(t, int64(len("key")+replaced.Size()), c.Usage())
+
+		c.Delete("key")
+		_, ok := c.Get("key", nil)
+		assert.False(t, ok)
+		assert.Empty(t, diskFiles(t, dir, "*"))
+		assert.Zero(t, c.Usage())
+	})
+
+	t.Run("Vary variants", func(t *testing.T) {
+		c, err := NewDiskCache(t.TempDir(), 0)
+		require.NoError(t, err)
+
+		respHeader := http.Header{"Vary": []string{"Accept-Language"}}
+		for _, lang := range []string{"en", "fr"} {
+			c.Set("page", Entry{
+				Header:        respHeader,
+				Body:          []byte(lang),
+				ExpiresAt:     time.Now().Add(1 * time.Hour),
+				RequestHeader: http.Header{"Accept-Language": []string{lang}},
+			})
+		}
+
+		got, ok := c.Get("page", http.Header{"Accept-Language": []string{"fr"}})
+		assert.True(t, ok)
+		assert.Equal(t, "fr", string(got.Body))
+
+		_, ok = c.Get("page", http.Header{"Accept-Language": []string{"de"}})
+		assert.False(t, ok)
+
+		var bodies []string
+		for _, v := range c.Variants("page") {
+			bodies = append(bodies, string(v.Body))
+		}
+		assert.ElementsMatch(t, []string{"en", "fr"}, bodies)
+	})
+
+	t.Run("Size cap", func(t *testing.T) {
+		dir := t.TempDir()
+		// Each key holds 4 bytes of key and 96 bytes of body
+		c, err := NewDiskCache(dir, 3*100)
+		require.NoError(t, err)
+
+		for i := range 4 {
+			if i == 3 {
+				c.Get("key0", nil)
+			}
+			c.Set(fmt.Sprintf("key%d", i), Entry{Body: make([]byte, 96), ExpiresAt: time.Now().Add(1 * time.Hour)})
+		}
+
+		_, ok := c.Get("key1", nil)
+		assert.False(t, ok)
+		_, ok = c.Get("key0", nil)
+		assert.True(t, ok)
+		assert.Equal(t, int64(3*100), c.Usage())
+		assert.Len(t, diskFiles(t, dir, "*.body"), 3)
+	})
+
+	t.Run("Recovery", func(t *testing.T) {
+		dir := t.TempDir()
+		c, err := NewDiskCache(dir, 0)
+		require.NoError(t, err)
+
+		c.Set("key", entry)
+		c.Set("expired", Entry{Body: []byte("expired"), ExpiresAt: time.Now().Add(-1 * time.Hour)})
+
+		// Leftovers of interrupted writes
+		sub := filepath.Dir(diskFiles(t, dir, "*.body")[0])
+		require.NoError(t, os.WriteFile(filepath.Join(sub, diskTempPrefix+"123"), []byte("partial"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(sub, "orphan"+diskBodySuffix), []byte("orphan"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(sub, "corrupt"+diskMetaSuffix), []byte("{"), 0o644))
+
+		c, err = NewDiskCache(dir, 0)
+		require.NoError(t, err)
+
+		got, ok := c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, entry.Body, got.Body)
+
+		_, ok = c.Get("expired", nil)
+		assert.False(t, ok)
+
+		assert.Len(t, diskFiles(t, dir, "*"), 2)
+		assert.Equal(t, int64(len("key")+entry.Size()), c.Usage())
+	})
+
+	t.Run("Range and PurgeTag", func(t *testing.T) {
+		c, err := NewDiskCache(t.TempDir(), 0)
+		require.NoError(t, err)
+
+		c.Set("tagged", entry)
+		c.Set("other", Entry{Body: []byte("other"), ExpiresAt: time.Now().Add(1 * time.Hour)})
+
+		var keys []string
+		c.Range(func(key string, e Entry) bool {
+			keys = append(keys, key)
+			assert.NotEmpty(t, e.Body)
+			return true
+		})
+		assert.ElementsMatch(t, []string{"tagged", "other"}, keys)
+
+		// Metadata comes from the index, the bodies are not read
+		sizes := make(map[string]int)
+		RangeMetadata(c, func(key string, e Entry, size int) bool {
+			sizes[key] = size
+			assert.Nil(t, e.Body)
+			return true
+		})
+		assert.Equal(t, map[string]int{"tagged": entry.Size(), "other": len("other")}, sizes)
+
+		assert.Equal(t, 1, PurgeTag(c, "home"))
+		_, ok := c.Get("tagged", nil)
+		assert.False(t, ok)
+		_, ok = c.Get("other", nil)
+		assert.True(t, ok)
+	})
+}
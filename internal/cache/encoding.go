@@ -0,0 +1,248 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// encodingVersion prefixes every encoded value, so stores shared by several
+// Cachefik releases can tell formats they don't understand from a miss.
+const encodingVersion = 1
+
+var errTruncated = errors.New("truncated entry")
+
+// encodeVariants serializes the variants stored under a key for the remote
+// backends.
+func encodeVariants(variants []Entry) []byte {
+	size := 1 + binary.MaxVarintLen64
+	for _, entry := range variants {
+		size += entry.Size() + 16*binary.MaxVarintLen64
+	}
+
+	e := encoder{buf: make([]byte, 0, size)}
+	e.buf = append(e.buf, encodingVersion)
+	e.uint(uint64(len(variants)))
+	for _, entry := range variants {
+		e.int(int64(entry.StatusCode))
+		e.header(entry.Header)
+		e.bytes(entry.Body)
+		e.time(entry.ExpiresAt)
+		e.header(entry.RequestHeader)
+		e.int(int64(entry.StaleWhileRevalidate))
+		e.int(int64(entry.StaleIfError))
+		e.bool(entry.MustRevalidate)
+		e.time(entry.RequestTime)
+		e.time(entry.ResponseTime)
+	}
+
+	return e.buf
+}
+
+// decodeVariants parses the output of encodeVariants.
+func decodeVariants(data []byte) ([]Entry, error) {
+	if len(data) == 0 {
+		return nil, errTruncated
+	}
+	if data[0] != encodingVersion {
+		return nil, fmt.Errorf("unsupported entry encoding version %d", data[0])
+	}
+
+	d := decoder{buf: data[1:]}
+	n := d.uint()
+	if n > uint64(len(d.buf)) {
+		return nil, errTruncated
+	}
+
+	variants := make([]Entry, 0, n)
+	for range n {
+		var entry Entry
+		entry.StatusCode = int(d.int())
+		entry.Header = d.header()
+		entry.Body = d.bytes()
+		entry.ExpiresAt = d.time()
+		entry.RequestHeader = d.header()
+		entry.StaleWhileRevalidate = time.Duration(d.int())
+		entry.StaleIfError = time.Duration(d.int())
+		entry.MustRevalidate = d.bool()
+		entry.RequestTime = d.time()
+		entry.ResponseTime = d.time()
+		variants = append(variants, entry)
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.buf) != 0 {
+		return nil, errors.New("trailing data after entry")
+	}
+
+	return variants, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) int(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *encoder) bytes(v []byte) {
+	e.uint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) string(v string) {
+	e.uint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// time encodes the zero time as 0, its Unix nanoseconds are out of range.
+func (e *encoder) time(v time.Time) {
+	if v.IsZero() {
+		e.int(0)
+		return
+	}
+
+	e.int(v.UnixNano())
+}
+
+func (e *encoder) header(h http.Header) {
+	e.uint(uint64(len(h)))
+	for k, vv := range h {
+		e.string(k)
+		e.uint(uint64(len(vv)))
+		for _, v := range vv {
+			e.string(v)
+		}
+	}
+}
+
+// decoder reads the fields written by encoder. The first error is kept and
+// every later read returns a zero value.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	if len(d.buf) == 0 {
+		d.err = errTruncated
+		return false
+	}
+
+	v := d.buf[0] != 0
+	d.buf = d.buf[1:]
+	return v
+}
+
+// bytes returns a slice of the decoded data, which must not be modified.
+func (d *decoder) bytes() []byte {
+	n := d.uint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errTruncated
+		return nil
+	}
+
+	v := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) time() time.Time {
+	v := d.int()
+	if v == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, v)
+}
+
+// header returns nil for an empty header, as http.Header fields are usually
+// left nil rather than empty.
+func (d *decoder) header() http.Header {
+	n := d.uint()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errTruncated
+		return nil
+	}
+
+	h := make(http.Header, n)
+	for range n {
+		k := d.string()
+		count := d.uint()
+		if d.err != nil {
+			return nil
+		}
+		if count > uint64(len(d.buf)) {
+			d.err = errTruncated
+			return nil
+		}
+
+		vv := make([]string, 0, count)
+		for range count {
+			vv = append(vv, d.string())
+		}
+		h[k] = vv
+	}
+
+	return h
+}
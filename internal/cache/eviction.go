@@ -0,0 +1,174 @@
+package cache
+
+import "container/list"
+
+// EvictionPolicy names the algorithm choosing which keys the memory cache
+// evicts once it is over budget.
+type EvictionPolicy string
+
+const (
+	// EvictionLRU evicts the least recently used key.
+	EvictionLRU EvictionPolicy = "lru"
+	// EvictionTinyLFU admits keys into the main space only when they are
+	// requested more often than the keys they would replace.
+	EvictionTinyLFU EvictionPolicy = "tinylfu"
+	// EvictionARC balances recency and frequency adaptively.
+	EvictionARC EvictionPolicy = "arc"
+)
+
+func (p EvictionPolicy) Valid() bool {
+	switch p {
+	case EvictionLRU, EvictionTinyLFU, EvictionARC:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p EvictionPolicy) new(maxBytes int64) evictionPolicy {
+	switch p {
+	case EvictionTinyLFU:
+		return newTinyLFUPolicy(maxBytes)
+	case EvictionARC:
+		return newARCPolicy(maxBytes)
+	default:
+		return newLRUPolicy()
+	}
+}
+
+// evictionPolicy tracks the stored keys and their sizes. The memory cache
+// holds its lock while calling it.
+type evictionPolicy interface {
+	// Add records a newly stored key.
+	Add(key string, size int64)
+	// Update records a stored key being replaced, which counts as a use.
+	Update(key string, size int64)
+	// Resize records the size of a stored key changing without it being used.
+	Resize(key string, size int64)
+	// Hit records a lookup served by a stored key.
+	Hit(key string)
+	// Miss records a lookup for a key that isn't stored.
+	Miss(key string)
+	// Remove forgets a key deleted from the cache.
+	Remove(key string)
+	// Victim forgets and returns the next key to evict, false when no key
+	// is tracked anymore.
+	Victim() (string, bool)
+}
+
+type lruPolicy struct {
+	keys *lruList
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{keys: newLRUList()}
+}
+
+func (p *lruPolicy) Add(key string, size int64) {
+	p.keys.pushFront(key, size)
+}
+
+func (p *lruPolicy) Update(key string, size int64) {
+	p.Resize(key, size)
+	p.keys.moveToFront(key)
+}
+
+func (p *lruPolicy) Resize(key string, size int64) {
+	p.keys.resize(key, size)
+}
+
+func (p *lruPolicy) Hit(key string) {
+	p.keys.moveToFront(key)
+}
+
+func (p *lruPolicy) Miss(string) {}
+
+func (p *lruPolicy) Remove(key string) {
+	p.keys.remove(key)
+}
+
+func (p *lruPolicy) Victim() (string, bool) {
+	key, _, ok := p.keys.removeBack()
+	return key, ok
+}
+
+type lruNode struct {
+	key  string
+	size int64
+}
+
+// lruList is a recency ordered list of keys, most recent first, keeping
+// track of their total size.
+type lruList struct {
+	list     *list.List
+	elements map[string]*list.Element
+	bytes    int64
+}
+
+func newLRUList() *lruList {
+	return &lruList{
+		list:     list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+func (l *lruList) len() int {
+	return l.list.Len()
+}
+
+func (l *lruList) contains(key string) bool {
+	_, ok := l.elements[key]
+	return ok
+}
+
+func (l *lruList) pushFront(key string, size int64) {
+	l.elements[key] = l.list.PushFront(&lruNode{key, size})
+	l.bytes += size
+}
+
+func (l *lruList) moveToFront(key string) {
+	if element, ok := l.elements[key]; ok {
+		l.list.MoveToFront(element)
+	}
+}
+
+func (l *lruList) resize(key string, size int64) {
+	if element, ok := l.elements[key]; ok {
+		node := element.Value.(*lruNode)
+		l.bytes += size - node.size
+		node.size = size
+	}
+}
+
+func (l *lruList) remove(key string) (int64, bool) {
+	element, ok := l.elements[key]
+	if !ok {
+		return 0, false
+	}
+
+	node := element.Value.(*lruNode)
+	l.list.Remove(element)
+	delete(l.elements, key)
+	l.bytes -= node.size
+
+	return node.size, true
+}
+
+func (l *lruList) back() (string, bool) {
+	element := l.list.Back()
+	if element == nil {
+		return "", false
+	}
+
+	return element.Value.(*lruNode).key, true
+}
+
+func (l *lruList) removeBack() (string, int64, bool) {
+	key, ok := l.back()
+	if !ok {
+		return "", 0, false
+	}
+
+	size, _ := l.remove(key)
+	return key, size, true
+}
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var evictionPolicies = []EvictionPolicy{EvictionLRU, EvictionTinyLFU, EvictionARC}
+
+func TestEvictionPolicies(t *testing.T) {
+	set := func(c *MemoryCache, key string) {
+		c.Set(key, Entry{Body: make([]byte, 96), ExpiresAt: time.Now().Add(1 * time.Hour)})
+	}
+
+	for _, policy := range evictionPolicies {
+		t.Run(string(policy), func(t *testing.T) {
+			// Each key holds 4 bytes of key and 96 bytes of body
+			c := NewMemoryCacheWithPolicy(10*100, policy)
+			for i := range 10 {
+				set(c, fmt.Sprintf("key%d", i))
+			}
+			assert.Equal(t, int64(10*100), c.Usage())
+
+			// A hot key only survives a scan of keys requested once when the
+			// policy accounts for frequency
+			for range 5 {
+				c.Get("key0", nil)
+			}
+			for i := range 50 {
+				key := fmt.Sprintf("s%03d", i)
+				if _, ok := c.Get(key, nil); !ok {
+					set(c, key)
+				}
+			}
+
+			_, ok := c.Get("key0", nil)
+			assert.Equal(t, policy != EvictionLRU, ok)
+			assert.LessOrEqual(t, c.Usage(), int64(10*100))
+
+			// Deleted keys are forgotten by the policy
+			var stored string
+			c.Range(func(key string, _ Entry) bool {
+				stored = key
+				return false
+			})
+			c.Delete(stored)
+			assert.LessOrEqual(t, c.Usage(), int64(9*100))
+			for i := range 20 {
+				set(c, fmt.Sprintf("n%03d", i))
+			}
+			assert.LessOrEqual(t, c.Usage(), int64(10*100))
+		})
+	}
+
+	t.Run("Unknown policy", func(t *testing.T) {
+		assert.False(t, EvictionPolicy("fifo").Valid())
+		assert.IsType(t, &lruPolicy{}, NewMemoryCacheWithPolicy(0, "fifo").policy)
+	})
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	p := newTinyLFUPolicy(1000)
+
+	// Keys leaving the window fill the main space for free
+	for i := range 10 {
+		key := fmt.Sprintf("hot%d", i)
+		p.Miss(key)
+		p.Add(key, 100)
+		p.Hit(key)
+	}
+
+	// Once it is full, a key seen once is rejected in favour of the main
+	// space's victim
+	p.Miss("once")
+	p.Add("once", 100)
+	victim, ok := p.Victim()
+	assert.True(t, ok)
+	assert.Equal(t, "once", victim)
+
+	// A key seen more often than the victim is admitted
+	for range 5 {
+		p.Miss("popular")
+	}
+	p.Add("popular", 100)
+	victim, ok = p.Victim()
+	assert.True(t, ok)
+	assert.NotEqual(t, "popular", victim)
+	assert.True(t, p.probation.contains("popular"))
+}
+
+func TestARCAdaptation(t *testing.T) {
+	p := newARCPolicy(200)
+	p.Add("a", 100)
+	p.Add("b", 100)
+
+	// Evicted keys are remembered as ghosts
+	victim, _ := p.Victim()
+	assert.Equal(t, "a", victim)
+	assert.True(t, p.b1.contains("a"))
+
+	// Requesting a recency ghost again grows the recency target, and the
+	// key is now considered frequent
+	p.Add("a", 100)
+	assert.Equal(t, int64(100), p.target)
+	assert.True(t, p.t2.contains("a"))
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(1024)
+	for range 20 {
+		s.increment("hot")
+	}
+	s.increment("cold")
+
+	assert.Equal(t, uint8(15), s.estimate("hot"))
+	assert.Equal(t, uint8(1), s.estimate("cold"))
+	assert.Equal(t, uint8(0), s.estimate("missing"))
+
+	s.reset()
+	assert.Equal(t, uint8(7), s.estimate("hot"))
+	assert.Equal(t, uint8(0), s.estimate("cold"))
+}
+
+// The simulations replay a trace against caches holding 1% of the keys
+// and compare the hit ratios of the policies.
+const (
+	traceKeys    = 100_000
+	traceLength  = 200_000
+	traceEntries = 1_000
+)
+
+// zipfTrace requests keys with a zipfian popularity, like a busy website.
+func zipfTrace() []string {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.01, 1, traceKeys-1)
+
+	trace := make([]string, traceLength)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key%06d", zipf.Uint64())
+	}
+
+	return trace
+}
+
+// scanTrace interleaves the zipfian trace with crawler scans requesting
+// keys once, which flush an LRU cache.
+func scanTrace() []string {
+	zipf := zipfTrace()
+
+	var trace []string
+	scanned := 0
+	for i, key := range zipf {
+		trace = append(trace, key)
+		if i%20_000 == 0 {
+			for range 5_000 {
+				trace = append(trace, fmt.Sprintf("scan%06d", scanned))
+				scanned++
+			}
+		}
+	}
+
+	return trace
+}
+
+func simulate(policy EvictionPolicy, trace []string) float64 {
+	// Keys are 9 or 10 bytes long
+	c := NewMemoryCacheWithPolicy(traceEntries*100, policy)
+	entry := Entry{Body: make([]byte, 90), ExpiresAt: time.Now().Add(1 * time.Hour)}
+
+	hits := 0
+	for _, key := range trace {
+		if _, ok := c.Get(key, nil); ok {
+			hits++
+			continue
+		}
+		c.Set(key, entry)
+	}
+
+	return float64(hits) / float64(len(trace))
+}
+
+func TestEvictionHitRatio(t *testing.T) {
+	if testing.Short() {
+		t.Skip("simulation skipped in short mode")
+	}
+
+	traces := []struct {
+		name  string
+		trace []string
+	}{
+		{"zipf", zipfTrace()},
+		{"scan", scanTrace()},
+	}
+
+	for _, tr := range traces {
+		ratios := make(map[EvictionPolicy]float64)
+		for _, policy := range evictionPolicies {
+			ratios[policy] = simulate(policy, tr.trace)
+			t.Logf("%-5s %-8s hit ratio %.3f", tr.name, policy, ratios[policy])
+		}
+
+		assert.Greater(t, ratios[EvictionTinyLFU], ratios[EvictionLRU], tr.name)
+		assert.Greater(t, ratios[EvictionARC], ratios[EvictionLRU], tr.name)
+	}
+}
+
+func BenchmarkEviction(b *testing.B) {
+	traces := []struct {
+		name  string
+		trace []string
+	}{
+		{"zipf", zipfTrace()},
+		{"scan", scanTrace()},
+	}
+
+	for _, tr := range traces {
+		for _, policy := range evictionPolicies {
+			b.Run(fmt.Sprintf("%s/%s", tr.name, policy), func(b *testing.B) {
+				var ratio float64
+				for b.Loop() {
+					ratio = simulate(policy, tr.trace)
+				}
+				b.ReportMetric(ratio, "hit-ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+)
+
+// Status codes that can be cached without explicit freshness information,
+// see RFC 9110 section 15.1.
+var heuristicallyCacheable = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+}
+
+// FreshnessLifetime returns the explicit freshness lifetime of a response in
+// a shared cache, per RFC 9111 section 4.2.1: s-maxage, then max-age, then
+// Expires relative to Date. It reports false when none is present.
+func FreshnessLifetime(header http.Header, cc CacheControl, now time.Time) (time.Duration, bool) {
+	if d, ok := cc.Seconds("s-maxage"); ok {
+		return d, true
+	}
+
+	if d, ok := cc.Seconds("max-age"); ok {
+		return d, true
+	}
+
+	if _, ok := header["Expires"]; ok {
+		// Invalid dates, such as "0", represent a time in the past
+		expires, err := http.ParseTime(header.Get("Expires"))
+		if err != nil {
+			return 0, true
+		}
+
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+
+		return max(0, expires.Sub(date)), true
+	}
+
+	return 0, false
+}
+
+// InitialAge estimates how old a response already was when it was received,
+// from its Date and Age headers (RFC 9111 section 4.2.3).
+func InitialAge(header http.Header, now time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		// Date has a one second resolution
+		apparentAge = max(0, now.Sub(date).Truncate(time.Second))
+	}
+
+	ageValue, _ := ageHeader(header)
+
+	return max(apparentAge, ageValue)
+}
+
+// Age returns the current age of the entry, as described in RFC 9111
+// section 4.2.3: the age it had when received, accounting for the upstream's
+// own Age header and the time the request took, plus the time it has been
+// resident in the cache.
+func (e Entry) Age() time.Duration {
+	if e.ResponseTime.IsZero() {
+		return 0
+	}
+
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		apparentAge = max(0, e.ResponseTime.Sub(date).Truncate(time.Second))
+	}
+
+	ageValue, _ := ageHeader(e.Header)
+	responseDelay := max(0, e.ResponseTime.Sub(e.RequestTime))
+	if e.RequestTime.IsZero() {
+		responseDelay = 0
+	}
+
+	correctedInitialAge := max(apparentAge, ageValue+responseDelay)
+	residentTime := time.Since(e.ResponseTime)
+
+	return correctedInitialAge + residentTime
+}
+
+// MustRevalidate reports whether a stale response must never be served
+// without successful revalidation.
+func MustRevalidate(cc CacheControl) bool {
+	return cc.Has("must-revalidate") ||
+		cc.Has("proxy-revalidate") ||
+		cc.Has("s-maxage") ||
+		(cc.Has("no-cache") && len(cc.Fields("no-cache")) == 0)
+}
+
+func ageHeader(header http.Header) (time.Duration, bool) {
+	value := header.Get("Age")
+	if value == "" {
+		return 0, false
+	}
+
+	return CacheControl{"age": value}.Seconds("age")
+}
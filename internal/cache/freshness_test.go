@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreshnessLifetime(t *testing.T) {
+	now := time.Date(1994, time.December, 1, 16, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name             string
+		headers          http.Header
+		expected         time.Duration
+		expectedExplicit bool
+	}{
+		{
+			name:             "No freshness information",
+			headers:          http.Header{},
+			expected:         0,
+			expectedExplicit: false,
+		},
+		{
+			name: "max-age",
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=3600"},
+			},
+			expected:         time.Hour,
+			expectedExplicit: true,
+		},
+		{
+			name: "s-maxage over max-age",
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=60, s-maxage=3600"},
+			},
+			expected:         time.Hour,
+			expectedExplicit: true,
+		},
+		{
+			// RFC 9111 section 5.3
+			name: "Expires relative to Date",
+			headers: http.Header{
+				"Date":    []string{"Thu, 01 Dec 1994 15:00:00 GMT"},
+				"Expires": []string{"Thu, 01 Dec 1994 16:00:00 GMT"},
+			},
+			expected:         time.Hour,
+			expectedExplicit: true,
+		},
+		{
+			name: "Expires without Date",
+			headers: http.Header{
+				"Expires": []string{"Thu, 01 Dec 1994 16:30:00 GMT"},
+			},
+			expected:         30 * time.Minute,
+			expectedExplicit: true,
+		},
+		{
+			name: "Expires in the past",
+			headers: http.Header{
+				"Date":    []string{"Thu, 01 Dec 1994 16:00:00 GMT"},
+				"Expires": []string{"Thu, 01 Dec 1994 15:00:00 GMT"},
+			},
+			expected:         0,
+			expectedExplicit: true,
+		},
+		{
+			name: "Invalid Expires",
+			headers: http.Header{
+				"Expires": []string{"0"},
+			},
+			expected:         0,
+			expectedExplicit: true,
+		},
+		{
+			name: "max-age over Expires",
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Expires":       []string{"Thu, 01 Dec 1994 17:00:00 GMT"},
+			},
+			expected:         time.Minute,
+			expectedExplicit: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, explicit := FreshnessLifetime(tc.headers, ParseCacheControl(tc.headers), now)
+			assert.Equal(t, tc.expected, got)
+			assert.Equal(t, tc.expectedExplicit, explicit)
+		})
+	}
+}
+
+func TestInitialAge(t *testing.T) {
+	now := time.Date(1994, time.December, 1, 16, 0, 0, 500*int(time.Millisecond), time.UTC)
+
+	testCases := []struct {
+		name     string
+		headers  http.Header
+		expected time.Duration
+	}{
+		{
+			name:     "No Date or Age",
+			headers:  http.Header{},
+			expected: 0,
+		},
+		{
+			name: "Apparent age from Date",
+			headers: http.Header{
+				"Date": []string{"Thu, 01 Dec 1994 15:59:50 GMT"},
+			},
+			expected: 10 * time.Second,
+		},
+		{
+			name: "Age header",
+			headers: http.Header{
+				"Age": []string{"30"},
+			},
+			expected: 30 * time.Second,
+		},
+		{
+			name: "Largest of Date and Age",
+			headers: http.Header{
+				"Date": []string{"Thu, 01 Dec 1994 15:59:50 GMT"},
+				"Age":  []string{"5"},
+			},
+			expected: 10 * time.Second,
+		},
+		{
+			name: "Date in the future",
+			headers: http.Header{
+				"Date": []string{"Thu, 01 Dec 1994 16:10:00 GMT"},
+			},
+			expected: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, InitialAge(tc.headers, now))
+		})
+	}
+}
+
+func TestMustRevalidate(t *testing.T) {
+	testCases := []struct {
+		cc       string
+		expected bool
+	}{
+		{cc: "max-age=60", expected: false},
+		{cc: "max-age=60, must-revalidate", expected: true},
+		{cc: "max-age=60, proxy-revalidate", expected: true},
+		{cc: "s-maxage=60", expected: true},
+		{cc: "no-cache", expected: true},
+		{cc: `no-cache="Set-Cookie"`, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.cc, func(t *testing.T) {
+			header := http.Header{"Cache-Control": []string{tc.cc}}
+			assert.Equal(t, tc.expected, MustRevalidate(ParseCacheControl(header)))
+		})
+	}
+}
+
+func TestEntryAge(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name     string
+		entry    Entry
+		expected time.Duration
+	}{
+		{
+			name:     "Unknown response time",
+			entry:    Entry{},
+			expected: 0,
+		},
+		{
+			name: "Resident time only",
+			entry: Entry{
+				Header:       http.Header{"Date": []string{now.Add(-10 * time.Second).UTC().Format(http.TimeFormat)}},
+				RequestTime:  now.Add(-10 * time.Second),
+				ResponseTime: now.Add(-10 * time.Second),
+			},
+			expected: 10 * time.Second,
+		},
+		{
+			name: "Upstream Age plus response delay",
+			entry: Entry{
+				Header: http.Header{
+					"Date": []string{now.Add(-10 * time.Second).UTC().Format(http.TimeFormat)},
+					"Age":  []string{"100"},
+				},
+				RequestTime:  now.Add(-12 * time.Second),
+				ResponseTime: now.Add(-10 * time.Second),
+			},
+			expected: 112 * time.Second,
+		},
+		{
+			name: "Apparent age from an old Date",
+			entry: Entry{
+				Header:       http.Header{"Date": []string{now.Add(-70 * time.Second).UTC().Format(http.TimeFormat)}},
+				RequestTime:  now.Add(-10 * time.Second),
+				ResponseTime: now.Add(-10 * time.Second),
+			},
+			expected: 70 * time.Second,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.InDelta(t, tc.expected.Seconds(), tc.entry.Age().Seconds(), 1.5)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// InvalidationKeys returns the keys to evict after an unsafe request, see
+// RFC 9111 section 4.4. A non-error response invalidates the target URI and
+// the same-origin URIs in its Location and Content-Location headers.
+func InvalidationKeys(r *http.Request, resp *http.Response) []string {
+	if safeMethod(r.Method) || resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return nil
+	}
+
+	target := &url.URL{
+		Scheme:   scheme(r),
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		RawQuery: r.URL.RawQuery,
+	}
+
+	keys := []string{urlKey(http.MethodGet, target.Scheme, target.Host, target)}
+	for _, field := range []string{"Location", "Content-Location"} {
+		value := resp.Header.Get(field)
+		if value == "" {
+			continue
+		}
+
+		ref, err := url.Parse(value)
+		if err != nil {
+			continue
+		}
+
+		// Invalidating another origin would let it evict entries it doesn't own
+		u := target.ResolveReference(ref)
+		if u.Scheme != target.Scheme || u.Host != target.Host {
+			continue
+		}
+
+		keys = append(keys, urlKey(http.MethodGet, u.Scheme, u.Host, u))
+	}
+
+	return keys
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
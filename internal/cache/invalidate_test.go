@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvalidationKeys(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		status   int
+		headers  http.Header
+		expected []string
+	}{
+		{
+			name:     "Safe method",
+			method:   http.MethodGet,
+			status:   http.StatusOK,
+			expected: nil,
+		},
+		{
+			name:     "Error response",
+			method:   http.MethodPost,
+			status:   http.StatusBadRequest,
+			expected: nil,
+		},
+		{
+			name:     "Target URI",
+			method:   http.MethodDelete,
+			status:   http.StatusNoContent,
+			expected: []string{"GET:http://example.com/items/42?a=1&b=2"},
+		},
+		{
+			name:   "Relative Location",
+			method: http.MethodPost,
+			status: http.StatusSeeOther,
+			headers: http.Header{
+				"Location": []string{"../items/43"},
+			},
+			expected: []string{
+				"GET:http://example.com/items/42?a=1&b=2",
+				"GET:http://example.com/items/43?",
+			},
+		},
+		{
+			name:   "Same-origin Content-Location",
+			method: http.MethodPut,
+			status: http.StatusOK,
+			headers: http.Header{
+				"Content-Location": []string{"http://example.com/items/42/v2"},
+			},
+			expected: []string{
+				"GET:http://example.com/items/42?a=1&b=2",
+				"GET:http://example.com/items/42/v2?",
+			},
+		},
+		{
+			name:   "Cross-origin Location",
+			method: http.MethodPatch,
+			status: http.StatusOK,
+			headers: http.Header{
+				"Location": []string{"http://other.com/items/42"},
+			},
+			expected: []string{"GET:http://example.com/items/42?a=1&b=2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/items/42?b=2&a=1", nil)
+			resp := &http.Response{StatusCode: tc.status, Header: tc.headers}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+
+			assert.Equal(t, tc.expected, InvalidationKeys(r, resp))
+		})
+	}
+}
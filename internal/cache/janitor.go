@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"slices"
+	"time"
+)
+
+// sweepBatch is how many keys a sweep checks per lock acquisition, so
+// requests are never blocked for long.
+const sweepBatch = 256
+
+type janitor struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartJanitor removes the entries that can't be served anymore every
+// interval, instead of waiting for a Get on them. Close stops it.
+func (c *MemoryCache) StartJanitor(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if interval <= 0 || c.janitor != nil {
+		return
+	}
+
+	j := &janitor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	c.janitor = j
+
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.sweep()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the janitor and waits for a sweep in progress to finish.
+func (c *MemoryCache) Close() error {
+	c.mu.Lock()
+	j := c.janitor
+	c.janitor = nil
+	c.mu.Unlock()
+
+	if j != nil {
+		close(j.stop)
+		<-j.done
+	}
+
+	return nil
+}
+
+// sweep removes the discardable variants of every key and returns how many
+// keys were removed.
+func (c *MemoryCache) sweep() int {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	c.mu.Unlock()
+
+	removed := 0
+	for batch := range slices.Chunk(keys, sweepBatch) {
+		c.mu.Lock()
+		for _, key := range batch {
+			if item, ok := c.items[key]; ok && c.discard(item) {
+				removed++
+			}
+		}
+		c.mu.Unlock()
+	}
+
+	return removed
+}
+
+// StartJanitor starts a janitor on every shard.
+func (c *ShardedCache) StartJanitor(interval time.Duration) {
+	for _, shard := range c.shards {
+		shard.StartJanitor(interval)
+	}
+}
+
+func (c *ShardedCache) Close() error {
+	for _, shard := range c.shards {
+		shard.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweep(t *testing.T) {
+	c := NewMemoryCache(0)
+	for i := range 2 * sweepBatch {
+		c.Set(fmt.Sprintf("expired%d", i), Entry{Body: []byte("expired"), ExpiresAt: time.Now().Add(-1 * time.Hour)})
+	}
+	c.Set("fresh", Entry{Body: []byte("fresh"), ExpiresAt: time.Now().Add(1 * time.Hour)})
+	c.Set("revalidatable", Entry{
+		Header:    http.Header{"Etag": []string{`"v1"`}},
+		ExpiresAt: time.Now().Add(-1 * time.Hour),
+	})
+
+	// Only one of the variants has expired
+	respHeader := http.Header{"Vary": []string{"Accept-Language"}}
+	c.Set("variants", Entry{
+		Header:        respHeader,
+		ExpiresAt:     time.Now().Add(1 * time.Hour),
+		RequestHeader: http.Header{"Accept-Language": []string{"en"}},
+	})
+	c.Set("variants", Entry{
+		Header:        respHeader,
+		ExpiresAt:     time.Now().Add(-1 * time.Hour),
+		RequestHeader: http.Header{"Accept-Language": []string{"fr"}},
+	})
+
+	assert.Equal(t, 2*sweepBatch, c.sweep())
+	assert.Len(t, c.items, 3)
+	assert.Len(t, c.items["variants"].variants, 1)
+
+	var used int64
+	for key, item := range c.items {
+		used += int64(len(key))
+		for _, entry := range item.variants {
+			used += int64(entry.Size())
+		}
+	}
+	assert.Equal(t, used, c.Usage())
+}
+
+func TestJanitor(t *testing.T) {
+	c := NewMemoryCache(0)
+	c.StartJanitor(10 * time.Millisecond)
+	// Starting twice keeps a single janitor
+	c.StartJanitor(10 * time.Millisecond)
+
+	c.Set("key", Entry{ExpiresAt: time.Now().Add(20 * time.Millisecond)})
+
+	assert.Eventually(t, func() bool {
+		return c.Usage() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, c.Close())
+	assert.NoError(t, c.Close())
+	assert.Nil(t, c.janitor)
+
+	t.Run("Sharded", func(t *testing.T) {
+		c := NewShardedCache(4, 0, EvictionLRU)
+		c.StartJanitor(10 * time.Millisecond)
+		defer c.Close()
+
+		for i := range 20 {
+			c.Set(fmt.Sprintf("key%d", i), Entry{ExpiresAt: time.Now().Add(20 * time.Millisecond)})
+		}
+
+		assert.Eventually(t, func() bool {
+			return c.Usage() == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+}
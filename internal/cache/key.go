@@ -3,18 +3,50 @@ package cache
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 func Key(r *http.Request) string {
-	queryString := r.URL.Query().Encode()
+	// HEAD requests share the entry of the GET request for the same URL
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+
+	return urlKey(method, scheme(r), r.Host, r.URL)
+}
+
+// URLKey returns the key of a GET request for an absolute URL.
+func URLKey(u *url.URL) string {
+	return urlKey(http.MethodGet, u.Scheme, u.Host, u)
+}
+
+// KeyURL returns the URL a key was built from.
+func KeyURL(key string) (*url.URL, error) {
+	_, rawURL, ok := strings.Cut(key, ":")
+	if !ok {
+		return nil, fmt.Errorf("malformed cache key %q", key)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys always end the path with "?", even without a query
+	u.ForceQuery = false
+	return u, nil
+}
 
+func urlKey(method, scheme, host string, u *url.URL) string {
 	return fmt.Sprintf(
 		"%s:%s://%s%s?%s",
-		r.Method,
-		scheme(r),
-		r.Host,
-		r.URL.Path,
-		queryString,
+		method,
+		scheme,
+		host,
+		u.Path,
+		u.Query().Encode(),
 	)
 }
 
@@ -40,6 +40,18 @@ func TestKey(t *testing.T) {
 			},
 			expected: "GET:https://example.com/test?foo=bar",
 		},
+		{
+			name: "HEAD shares the GET key",
+			request: &http.Request{
+				Method: http.MethodHead,
+				Host:   "example.com",
+				URL: &url.URL{
+					Path:     "/test",
+					RawQuery: "foo=bar",
+				},
+			},
+			expected: "GET:http://example.com/test?foo=bar",
+		},
 		{
 			name: "HTTP POST",
 			request: &http.Request{
@@ -72,3 +84,22 @@ func TestKey(t *testing.T) {
 		})
 	}
 }
+
+func TestKeyURL(t *testing.T) {
+	u, err := url.Parse("https://example.com/search?q=go&page=1")
+	assert.NoError(t, err)
+
+	key := URLKey(u)
+	assert.Equal(t, "GET:https://example.com/search?page=1&q=go", key)
+
+	got, err := KeyURL(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/search?page=1&q=go", got.String())
+
+	got, err = KeyURL("GET:http://example.com/?")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/", got.String())
+
+	_, err = KeyURL("malformed")
+	assert.Error(t, err)
+}
@@ -0,0 +1,665 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// memcachedInline values hold the encoded variants, memcachedManifest
+	// values list the chunks they were split into.
+	memcachedInline   byte = 0
+	memcachedManifest byte = 1
+
+	// memcachedItemOverhead is left free in every item for its key and
+	// metadata, which count towards the server's item size limit.
+	memcachedItemOverhead = 1024
+
+	// memcachedMaxRelativeTTL is the largest TTL memcached reads as a number of
+	// seconds, larger values are Unix timestamps.
+	memcachedMaxRelativeTTL = 30 * 24 * 60 * 60
+
+	// memcachedCASRetries bounds the attempts at updating a tag item other
+	// writers keep changing.
+	memcachedCASRetries = 5
+)
+
+type MemcachedOptions struct {
+	Addr string
+	// PoolSize is the number of idle connections kept open.
+	PoolSize int
+	// Timeout bounds dialing and every round trip.
+	Timeout time.Duration
+	// Prefix namespaces the keys so several caches can share a server.
+	Prefix string
+	// Retention is how long expired entries that can be revalidated are
+	// kept, see retainUntil.
+	Retention time.Duration
+	// MaxItemSize is the server's item size limit, 1MB by default. Larger
+	// values are split into chunks.
+	MaxItemSize int
+	// BreakerThreshold consecutive failures make the cache a bypass for
+	// BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// MemcachedCache stores entries in memcached using the meta text protocol.
+// The variants of a key are stored together, in chunks listed by a manifest
+// when they exceed the item size limit. Keys are hashed to fit memcached's
+// key constraints, so Range can't list them and visits nothing. Tags are
+// indexed by an item per tag listing the tagged keys.
+type MemcachedCache struct {
+	addr      string
+	pool      *connPool[*mcConn]
+	breaker   *Breaker
+	prefix    string
+	retention time.Duration
+	chunkSize int
+}
+
+func NewMemcachedCache(opts MemcachedOptions) *MemcachedCache {
+	if opts.MaxItemSize <= 0 {
+		opts.MaxItemSize = 1024 * 1024
+	}
+	if opts.BreakerThreshold <= 0 {
+		opts.BreakerThreshold = 5
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = 10 * time.Second
+	}
+
+	return &MemcachedCache{
+		addr: opts.Addr,
+		pool: newConnPool(opts.PoolSize, func() (*mcConn, error) {
+			return dialMemcached(opts.Addr, opts.Timeout)
+		}),
+		breaker:   NewBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+		prefix:    opts.Prefix,
+		retention: opts.Retention,
+		chunkSize: max(opts.MaxItemSize-memcachedItemOverhead, 1),
+	}
+}
+
+func (c *MemcachedCache) hashedKey(kind, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return c.prefix + kind + ":" + hex.EncodeToString(sum[:])
+}
+
+func (c *MemcachedCache) entryKey(key string) string {
+	return c.hashedKey("entry", key)
+}
+
+func (c *MemcachedCache) tagKey(tag string) string {
+	return c.hashedKey("tag", tag)
+}
+
+func (c *MemcachedCache) chunkKey(id string, i int) string {
+	return c.prefix + "chunk:" + id + ":" + strconv.Itoa(i)
+}
+
+// run calls fn with a pooled connection unless the circuit breaker is open.
+func (c *MemcachedCache) run(fn func(conn *mcConn) error) error {
+	if !c.breaker.Allow() {
+		return errBreakerOpen
+	}
+
+	conn, err := c.pool.get()
+	if err == nil {
+		err = fn(conn)
+	}
+
+	// Error replies don't mean the server is down, but the connection is
+	// not reused as a client error may leave it out of sync
+	failure := err
+	var reply mcError
+	if errors.As(err, &reply) {
+		failure = nil
+	}
+	if conn != nil {
+		c.pool.put(conn, err == nil)
+	}
+	if c.breaker.Record(failure) {
+		slog.Warn("memcached cache unavailable, bypassing it", "addr", c.addr, "error", err)
+	}
+
+	return err
+}
+
+// variants returns the variants stored under key, nil if they can't be
+// read back, such as when a chunk was evicted.
+func (c *MemcachedCache) variants(conn *mcConn, key string) ([]Entry, error) {
+	replies, err := conn.roundTrip(mcCommand{line: "mg " + c.entryKey(key) + " v"})
+	if err != nil {
+		return nil, err
+	}
+
+	value := replies[0].value
+	if value == nil {
+		return nil, nil
+	}
+
+	data, err := c.readValue(conn, value)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	variants, err := decodeVariants(data)
+	if err != nil {
+		slog.Warn("ignoring undecodable cache entry", "key", key, "error", err)
+		return nil, nil
+	}
+
+	return variants, nil
+}
+
+// readValue returns the encoded variants of a stored value, fetching its
+// chunks for a manifest. It returns nil when the value is invalid.
+func (c *MemcachedCache) readValue(conn *mcConn, value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	switch value[0] {
+	case memcachedInline:
+		return value[1:], nil
+	case memcachedManifest:
+		m, ok := parseManifest(value[1:])
+		if !ok {
+			return nil, nil
+		}
+
+		cmds := make([]mcCommand, m.chunks)
+		for i := range cmds {
+			cmds[i] = mcCommand{line: "mg " + c.chunkKey(m.id, i) + " v"}
+		}
+
+		replies, err := conn.roundTrip(cmds...)
+		if err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, 0, m.size)
+		for _, reply := range replies {
+			if reply.value == nil {
+				return nil, nil
+			}
+			data = append(data, reply.value...)
+		}
+
+		if len(data) != m.size || crc32.ChecksumIEEE(data) != m.checksum {
+			return nil, nil
+		}
+
+		return data, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (c *MemcachedCache) Get(key string, header http.Header) (Entry, bool) {
+	var variants []Entry
+	err := c.run(func(conn *mcConn) (err error) {
+		variants, err = c.variants(conn, key)
+		return err
+	})
+	if err != nil {
+		return Entry{}, false
+	}
+
+	for _, entry := range variants {
+		if !entry.Discardable() && entry.Matches(header) {
+			return entry, true
+		}
+	}
+
+	return Entry{}, false
+}
+
+// Set merges the entry with the stored variants. Chunks are written before
+// the manifest listing them, so readers never see a partial value.
+func (c *MemcachedCache) Set(key string, entry Entry) {
+	entry.Tier = 0
+
+	err := c.run(func(conn *mcConn) error {
+		stored, err := c.variants(conn, key)
+		if err != nil {
+			return err
+		}
+
+		variants := append([]Entry{entry}, slices.DeleteFunc(stored, func(v Entry) bool {
+			return entry.supersedes(v) || v.Discardable()
+		})...)
+
+		var until time.Time
+		for _, v := range variants {
+			until = later(until, retainUntil(v, c.retention))
+		}
+
+		if !time.Now().Before(until) {
+			_, err := conn.roundTrip(mcCommand{line: "md " + c.entryKey(key)})
+			return err
+		}
+		ttl := memcachedTTL(until)
+
+		data := encodeVariants(variants)
+		var cmds []mcCommand
+		var value []byte
+		if len(data) < c.chunkSize {
+			value = append([]byte{memcachedInline}, data...)
+		} else {
+			m := manifest{id: rand.Text(), size: len(data), checksum: crc32.ChecksumIEEE(data)}
+			for i, chunk := range slices.Collect(slices.Chunk(data, c.chunkSize)) {
+				cmds = append(cmds, mcCommand{line: fmt.Sprintf("ms %s %d T%d", c.chunkKey(m.id, i), len(chunk), ttl), data: chunk})
+				m.chunks++
+			}
+			value = append([]byte{memcachedManifest}, m.encode()...)
+		}
+
+		cmds = append(cmds, mcCommand{line: fmt.Sprintf("ms %s %d T%d", c.entryKey(key), len(value), ttl), data: value})
+
+		replies, err := conn.roundTrip(cmds...)
+		if err != nil {
+			return err
+		}
+
+		for _, reply := range replies {
+			if reply.status != "HD" {
+				return mcError("not stored: " + reply.status)
+			}
+		}
+
+		for _, tag := range entry.Tags() {
+			if err := c.indexTag(conn, tag, key, until); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errBreakerOpen) {
+		slog.Warn("memcached cache write failed", "key", key, "error", err)
+	}
+}
+
+// indexTag adds key to the item listing the keys tagged with tag, and keeps
+// the item at least until the entry expires. The item is rewritten with
+// compare and swap so concurrent writers don't drop each other's keys.
+func (c *MemcachedCache) indexTag(conn *mcConn, tag, key string, until time.Time) error {
+	tagKey := c.tagKey(tag)
+	for range memcachedCASRetries {
+		replies, err := conn.roundTrip(mcCommand{line: "mg " + tagKey + " v c t"})
+		if err != nil {
+			return err
+		}
+
+		reply := replies[0]
+		keys := tagKeys(reply.value)
+
+		// Items without expiry, t-1, are given one
+		itemUntil := until
+		if remaining, err := strconv.ParseInt(reply.flag('t'), 10, 64); err == nil && remaining > 0 {
+			expiry := time.Now().Add(time.Duration(remaining) * time.Second)
+			if slices.Contains(keys, key) && !expiry.Before(until) {
+				return nil
+			}
+			itemUntil = later(until, expiry)
+		}
+		keys = append(slices.DeleteFunc(keys, func(k string) bool { return k == key }), key)
+
+		value := []byte(strings.Join(keys, "\n"))
+		if len(value) > c.chunkSize {
+			keys, err = c.pruneTagKeys(conn, tag, keys)
+			if err != nil {
+				return err
+			}
+			value = []byte(strings.Join(keys, "\n"))
+		}
+
+		// A missing item is added, so a concurrent writer's isn't replaced
+		mode := "ME"
+		if reply.status == "VA" {
+			mode = "C" + reply.flag('c')
+		}
+
+		replies, err = conn.roundTrip(mcCommand{
+			line: fmt.Sprintf("ms %s %d T%d %s", tagKey, len(value), memcachedTTL(itemUntil), mode),
+			data: value,
+		})
+		if err != nil {
+			return err
+		}
+
+		switch replies[0].status {
+		case "HD":
+			return nil
+		case "EX", "NS", "NF":
+			// Changed or purged since it was read
+			continue
+		default:
+			return mcError("tag index not stored: " + replies[0].status)
+		}
+	}
+
+	return mcError("tag index not stored: too many concurrent updates")
+}
+
+// pruneTagKeys drops the keys whose entry is gone, then the oldest keys if
+// the list still doesn't fit in an item.
+func (c *MemcachedCache) pruneTagKeys(conn *mcConn, tag string, keys []string) ([]string, error) {
+	cmds := make([]mcCommand, len(keys))
+	for i, key := range keys {
+		cmds[i] = mcCommand{line: "mg " + c.entryKey(key)}
+	}
+
+	replies, err := conn.roundTrip(cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []string
+	size := 0
+	for i, reply := range replies {
+		if reply.status != "EN" {
+			live = append(live, keys[i])
+			size += len(keys[i]) + 1
+		}
+	}
+
+	dropped := 0
+	for ; size > c.chunkSize && len(live) > 1; dropped++ {
+		size -= len(live[0]) + 1
+		live = live[1:]
+	}
+	if dropped > 0 {
+		slog.Warn("memcached tag index full, purging the tag will miss the oldest keys", "tag", tag, "dropped", dropped)
+	}
+
+	return live, nil
+}
+
+// tagKeys returns the distinct keys listed by a tag item.
+func tagKeys(value []byte) []string {
+	var keys []string
+	seen := make(map[string]struct{})
+	for _, key := range strings.Split(string(value), "\n") {
+		if _, ok := seen[key]; !ok && key != "" {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func (c *MemcachedCache) Delete(key string) {
+	c.run(func(conn *mcConn) error {
+		_, err := conn.roundTrip(mcCommand{line: "md " + c.entryKey(key)})
+		return err
+	})
+}
+
+func (c *MemcachedCache) Variants(key string) []Entry {
+	var variants []Entry
+	c.run(func(conn *mcConn) (err error) {
+		variants, err = c.variants(conn, key)
+		return err
+	})
+
+	return variants
+}
+
+// Range visits nothing, memcached can't list its keys.
+func (c *MemcachedCache) Range(func(key string, entry Entry) bool) {}
+
+// CanList returns false, see Range.
+func (c *MemcachedCache) CanList() bool {
+	return false
+}
+
+// PurgeTag removes every key with a variant tagged with tag. Keys the tag
+// item lists are only removed when they still carry the tag, as they may
+// have been stored again without it.
+func (c *MemcachedCache) PurgeTag(tag string) int {
+	purged := 0
+	c.run(func(conn *mcConn) error {
+		replies, err := conn.roundTrip(mcCommand{line: "mg " + c.tagKey(tag) + " v"})
+		if err != nil || replies[0].value == nil {
+			return err
+		}
+
+		keys := tagKeys(replies[0].value)
+		gets := make([]mcCommand, len(keys))
+		for i, key := range keys {
+			gets[i] = mcCommand{line: "mg " + c.entryKey(key) + " v"}
+		}
+
+		replies, err = conn.roundTrip(gets...)
+		if err != nil {
+			return err
+		}
+
+		cmds := []mcCommand{{line: "md " + c.tagKey(tag)}}
+		for i, reply := range replies {
+			data, err := c.readValue(conn, reply.value)
+			if err != nil {
+				return err
+			}
+
+			variants, err := decodeVariants(data)
+			if err == nil && slices.Contains(variantTags(variants), tag) {
+				cmds = append(cmds, mcCommand{line: "md " + c.entryKey(keys[i])})
+			}
+		}
+
+		replies, err = conn.roundTrip(cmds...)
+		if err != nil {
+			return err
+		}
+
+		for _, reply := range replies[1:] {
+			if reply.status == "HD" {
+				purged++
+			}
+		}
+		return nil
+	})
+
+	return purged
+}
+
+func (c *MemcachedCache) Close() error {
+	c.pool.close()
+	return nil
+}
+
+// memcachedTTL converts an expiry time to the T flag of the meta commands.
+func memcachedTTL(until time.Time) int64 {
+	ttl := int64(time.Until(until).Seconds()) + 1
+	if ttl > memcachedMaxRelativeTTL {
+		return until.Unix() + 1
+	}
+
+	return ttl
+}
+
+// manifest lists the chunks of a value too large for a single item.
+type manifest struct {
+	id       string
+	chunks   int
+	size     int
+	checksum uint32
+}
+
+func (m manifest) encode() []byte {
+	b := binary.AppendUvarint(nil, uint64(m.chunks))
+	b = binary.AppendUvarint(b, uint64(m.size))
+	b = binary.BigEndian.AppendUint32(b, m.checksum)
+	return append(b, m.id...)
+}
+
+func parseManifest(b []byte) (manifest, bool) {
+	var m manifest
+	chunks, n := binary.Uvarint(b)
+	if n <= 0 {
+		return m, false
+	}
+	b = b[n:]
+
+	size, n := binary.Uvarint(b)
+	if n <= 0 || len(b[n:]) < 4 {
+		return m, false
+	}
+	b = b[n:]
+
+	m.chunks = int(chunks)
+	m.size = int(size)
+	m.checksum = binary.BigEndian.Uint32(b)
+	m.id = string(b[4:])
+	return m, m.id != "" && m.chunks > 0
+}
+
+// mcError is an error reply from memcached.
+type mcError string
+
+func (e mcError) Error() string {
+	return string(e)
+}
+
+type mcCommand struct {
+	line string
+	// data is sent after the command line when not nil
+	data []byte
+}
+
+type mcReply struct {
+	// status is the reply code, such as HD, VA, EN or NF
+	status string
+	// value is the value returned with a VA reply
+	value []byte
+	// flags are the returned flags, such as c1234 for the CAS value
+	flags []string
+}
+
+// flag returns the token of the returned flag f, empty when absent.
+func (r mcReply) flag(f byte) string {
+	for _, flag := range r.flags {
+		if flag[0] == f {
+			return flag[1:]
+		}
+	}
+
+	return ""
+}
+
+type mcConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+func dialMemcached(addr string, timeout time.Duration) (*mcConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mcConn{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		timeout: timeout,
+	}, nil
+}
+
+func (c *mcConn) Close() error {
+	return c.conn.Close()
+}
+
+// roundTrip sends the commands in a single round trip and returns their
+// replies. An error reply fails the whole round trip.
+func (c *mcConn) roundTrip(cmds ...mcCommand) ([]mcReply, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	for _, cmd := range cmds {
+		c.w.WriteString(cmd.line)
+		c.w.WriteString("\r\n")
+		if cmd.data != nil {
+			c.w.Write(cmd.data)
+			c.w.WriteString("\r\n")
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]mcReply, len(cmds))
+	var replyErr error
+	for i := range cmds {
+		reply, err := c.readReply()
+		if err != nil {
+			var mcErr mcError
+			if !errors.As(err, &mcErr) {
+				return nil, err
+			}
+			if replyErr == nil {
+				replyErr = err
+			}
+		}
+		replies[i] = reply
+	}
+
+	return replies, replyErr
+}
+
+func (c *mcConn) readReply() (mcReply, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return mcReply{}, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	status, rest, _ := strings.Cut(line, " ")
+	switch status {
+	case "VA":
+		sizeField, flags, _ := strings.Cut(rest, " ")
+		size, err := strconv.Atoi(sizeField)
+		if err != nil || size < 0 {
+			return mcReply{}, fmt.Errorf("malformed memcached reply %q", line)
+		}
+
+		value := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, value); err != nil {
+			return mcReply{}, err
+		}
+		if !bytes.HasSuffix(value, []byte("\r\n")) {
+			return mcReply{}, fmt.Errorf("malformed memcached value")
+		}
+
+		return mcReply{status: status, value: value[:size], flags: strings.Fields(flags)}, nil
+	case "ERROR", "CLIENT_ERROR", "SERVER_ERROR":
+		return mcReply{status: status}, mcError(line)
+	default:
+		return mcReply{status: status, flags: strings.Fields(rest)}, nil
+	}
+}
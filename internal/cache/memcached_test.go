@@ -0,0 +1,365 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memcachedServer is an in-process stand-in for memcached implementing the
+// meta commands used by MemcachedCache.
+type memcachedServer struct {
+	ln          net.Listener
+	maxItemSize int
+
+	mu      sync.Mutex
+	items   map[string][]byte
+	ttls    map[string]int64
+	cas     map[string]uint64
+	nextCAS uint64
+	broken  bool
+}
+
+func newMemcachedServer(t *testing.T, maxItemSize int) *memcachedServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &memcachedServer{
+		ln:          ln,
+		maxItemSize: maxItemSize,
+		items:       make(map[string][]byte),
+		ttls:        make(map[string]int64),
+		cas:         make(map[string]uint64),
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *memcachedServer) keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.items {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func (s *memcachedServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		var data []byte
+		if len(fields) > 2 && fields[0] == "ms" {
+			size, _ := strconv.Atoi(fields[2])
+			data = make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			data = data[:size]
+		}
+
+		s.handle(w, fields, data)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *memcachedServer) handle(w *bufio.Writer, fields []string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.broken {
+		w.WriteString("SERVER_ERROR out of memory\r\n")
+		return
+	}
+
+	key := fields[1]
+	switch fields[0] {
+	case "mg":
+		value, ok := s.items[key]
+		if !ok {
+			w.WriteString("EN\r\n")
+			return
+		}
+
+		reply := "HD"
+		for _, flag := range fields[2:] {
+			switch flag {
+			case "v":
+				reply = fmt.Sprintf("VA %d", len(value))
+				defer fmt.Fprintf(w, "%s\r\n", value)
+			case "c":
+				reply += fmt.Sprintf(" c%d", s.cas[key])
+			case "t":
+				reply += fmt.Sprintf(" t%d", s.ttls[key])
+			}
+		}
+		fmt.Fprintf(w, "%s\r\n", reply)
+	case "ms":
+		value := data
+		var ttl int64
+		_, exists := s.items[key]
+		for _, flag := range fields[3:] {
+			switch {
+			case flag == "MA":
+				value = append(s.items[key], data...)
+			case flag == "ME" && exists:
+				w.WriteString("NS\r\n")
+				return
+			case flag[0] == 'C' && !exists:
+				w.WriteString("NF\r\n")
+				return
+			case flag[0] == 'C' && flag[1:] != strconv.FormatUint(s.cas[key], 10):
+				w.WriteString("EX\r\n")
+				return
+			case flag[0] == 'T':
+				ttl, _ = strconv.ParseInt(flag[1:], 10, 64)
+			}
+		}
+		if len(key)+len(value) > s.maxItemSize {
+			w.WriteString("SERVER_ERROR object too large for cache\r\n")
+			return
+		}
+		s.items[key] = value
+		s.ttls[key] = ttl
+		s.nextCAS++
+		s.cas[key] = s.nextCAS
+		w.WriteString("HD\r\n")
+	case "md":
+		if _, ok := s.items[key]; !ok {
+			w.WriteString("NF\r\n")
+			return
+		}
+		delete(s.items, key)
+		w.WriteString("HD\r\n")
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+}
+
+func TestMemcachedCache(t *testing.T) {
+	entry := Entry{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}, "Surrogate-Key": []string{"home"}},
+		Body:       []byte("cached content"),
+		ExpiresAt:  time.Now().Add(1 * time.Hour),
+	}
+
+	newCache := func(t *testing.T, s *memcachedServer) *MemcachedCache {
+		c := NewMemcachedCache(MemcachedOptions{
+			Addr:        s.ln.Addr().String(),
+			Timeout:     1 * time.Second,
+			Prefix:      "test:",
+			Retention:   24 * time.Hour,
+			MaxItemSize: s.maxItemSize,
+		})
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	t.Run("Set and Get", func(t *testing.T) {
+		s := newMemcachedServer(t, 1024*1024)
+		c := newCache(t, s)
+
+		c.Set("GET:http://example.com/some path?", entry)
+		got, ok := c.Get("GET:http://example.com/some path?", nil)
+		assert.True(t, ok)
+		assert.Equal(t, entry.Body, got.Body)
+		assert.Equal(t, entry.Header, got.Header)
+
+		keys := s.keys("test:entry:")
+		require.Len(t, keys, 1)
+		assert.LessOrEqual(t, len(keys[0]), 250, "memcached keys are limited to 250 bytes")
+		s.mu.Lock()
+		assert.InDelta(t, 3600, s.ttls[keys[0]], 2)
+		s.mu.Unlock()
+
+		assert.Len(t, c.Variants("GET:http://example.com/some path?"), 1)
+
+		_, ok = c.Get("missing", nil)
+		assert.False(t, ok)
+
+		c.Delete("GET:http://example.com/some path?")
+		_, ok = c.Get("GET:http://example.com/some path?", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("Chunked bodies", func(t *testing.T) {
+		s := newMemcachedServer(t, 4096)
+		c := newCache(t, s)
+
+		large := entry
+		large.Body = make([]byte, 10_000)
+		for i := range large.Body {
+			large.Body[i] = byte(i)
+		}
+		c.Set("key", large)
+
+		got, ok := c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, large.Body, got.Body)
+		assert.Len(t, s.keys("test:chunk:"), 4)
+
+		// A missing chunk turns the entry into a miss
+		chunk := s.keys("test:chunk:")[0]
+		s.mu.Lock()
+		delete(s.items, chunk)
+		s.mu.Unlock()
+		_, ok = c.Get("key", nil)
+		assert.False(t, ok)
+
+		c.Set("key", large)
+		_, ok = c.Get("key", nil)
+		assert.True(t, ok)
+	})
+
+	t.Run("PurgeTag", func(t *testing.T) {
+		s := newMemcachedServer(t, 1024*1024)
+		c := newCache(t, s)
+
+		c.Set("a", entry)
+		c.Set("a", entry)
+		c.Set("b", entry)
+		c.Set("c", Entry{Body: []byte("untagged"), ExpiresAt: time.Now().Add(1 * time.Hour)})
+
+		s.mu.Lock()
+		assert.Equal(t, "a\nb", string(s.items[c.tagKey("home")]), "keys are listed once")
+		assert.Equal(t, s.ttls[c.entryKey("a")], s.ttls[c.tagKey("home")], "the tag item is kept as long as its entries")
+		s.mu.Unlock()
+
+		assert.Equal(t, 2, PurgeTag(c, "home"))
+		_, ok := c.Get("a", nil)
+		assert.False(t, ok)
+		_, ok = c.Get("c", nil)
+		assert.True(t, ok)
+		assert.Zero(t, PurgeTag(c, "home"))
+	})
+
+	t.Run("PurgeTag skips keys stored again without the tag", func(t *testing.T) {
+		c := newCache(t, newMemcachedServer(t, 1024*1024))
+
+		c.Set("a", entry)
+		c.Set("b", entry)
+		c.Set("b", Entry{StatusCode: http.StatusOK, Body: []byte("untagged"), ExpiresAt: time.Now().Add(1 * time.Hour)})
+
+		assert.Equal(t, 1, PurgeTag(c, "home"))
+		_, ok := c.Get("a", nil)
+		assert.False(t, ok)
+		got, ok := c.Get("b", nil)
+		assert.True(t, ok)
+		assert.Equal(t, []byte("untagged"), got.Body)
+	})
+
+	t.Run("Tag index size", func(t *testing.T) {
+		s := newMemcachedServer(t, 4096)
+		c := newCache(t, s)
+
+		for i := range 200 {
+			c.Set(fmt.Sprintf("GET:http://example.com/page/%d", i), entry)
+			if i < 100 {
+				// Evicted entries are the first to go
+				s.mu.Lock()
+				delete(s.items, c.entryKey(fmt.Sprintf("GET:http://example.com/page/%d", i)))
+				s.mu.Unlock()
+			}
+		}
+
+		s.mu.Lock()
+		keys := tagKeys(s.items[c.tagKey("home")])
+		s.mu.Unlock()
+		assert.LessOrEqual(t, len(strings.Join(keys, "\n")), c.chunkSize)
+		assert.NotContains(t, keys, "GET:http://example.com/page/0")
+		assert.Contains(t, keys, "GET:http://example.com/page/199")
+
+		_, ok := c.Get("GET:http://example.com/page/199", nil)
+		assert.True(t, ok)
+		assert.Positive(t, PurgeTag(c, "home"))
+		_, ok = c.Get("GET:http://example.com/page/199", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		assert.InDelta(t, 60, memcachedTTL(time.Now().Add(1*time.Minute)), 1)
+
+		until := time.Now().Add(60 * 24 * time.Hour)
+		assert.InDelta(t, until.Unix(), memcachedTTL(until), 1, "long TTLs are absolute")
+	})
+
+	t.Run("Server errors", func(t *testing.T) {
+		s := newMemcachedServer(t, 1024*1024)
+		c := newCache(t, s)
+		c.Set("key", entry)
+
+		s.mu.Lock()
+		s.broken = true
+		s.mu.Unlock()
+
+		for range 10 {
+			_, ok := c.Get("key", nil)
+			assert.False(t, ok)
+		}
+		assert.True(t, c.breaker.Allow(), "error replies don't open the circuit")
+
+		s.mu.Lock()
+		s.broken = false
+		s.mu.Unlock()
+
+		_, ok := c.Get("key", nil)
+		assert.True(t, ok)
+	})
+
+	t.Run("Circuit breaker", func(t *testing.T) {
+		s := newMemcachedServer(t, 1024*1024)
+		c := NewMemcachedCache(MemcachedOptions{
+			Addr:             s.ln.Addr().String(),
+			Timeout:          1 * time.Second,
+			BreakerThreshold: 2,
+			BreakerCooldown:  1 * time.Hour,
+		})
+		defer c.Close()
+
+		c.pool.close()
+		s.ln.Close()
+		for range 2 {
+			_, ok := c.Get("key", nil)
+			assert.False(t, ok)
+		}
+
+		assert.ErrorIs(t, c.run(func(*mcConn) error { return nil }), errBreakerOpen)
+	})
+}
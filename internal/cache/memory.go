@@ -1,44 +1,74 @@
 package cache
 
 import (
-	"container/list"
+	"net/http"
+	"slices"
 	"sync"
 )
 
 type cacheItem struct {
-	key   string
-	entry Entry
+	key      string
+	variants []Entry
+	// size is the key length plus the size of every variant
+	size int64
 }
 
 type MemoryCache struct {
 	mu       sync.Mutex
-	capacity int
-	list     *list.List
-	items    map[string]*list.Element
+	maxBytes int64
+	used     int64
+	items    map[string]*cacheItem
+	policy   evictionPolicy
+	janitor  *janitor
+	// tags indexes the keys by the tags of their variants
+	tags map[string]map[string]struct{}
 }
 
-func NewMemoryCache() *MemoryCache {
+// NewMemoryCache returns an LRU cache evicting entries once their total
+// size, see Entry.Size, exceeds maxBytes. Zero or less means no limit.
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return NewMemoryCacheWithPolicy(maxBytes, EvictionLRU)
+}
+
+// NewMemoryCacheWithPolicy returns a cache evicting entries with the given
+// policy once their total size exceeds maxBytes. Unknown policies fall back
+// to LRU, see EvictionPolicy.Valid.
+func NewMemoryCacheWithPolicy(maxBytes int64, policy EvictionPolicy) *MemoryCache {
 	return &MemoryCache{
-		capacity: 1000,
-		list:     list.New(),
-		items:    make(map[string]*list.Element),
+		maxBytes: maxBytes,
+		items:    make(map[string]*cacheItem),
+		policy:   policy.new(maxBytes),
+		tags:     make(map[string]map[string]struct{}),
 	}
 }
 
-func (c *MemoryCache) Get(key string) (Entry, bool) {
+// Usage returns the total size of the stored entries in bytes.
+func (c *MemoryCache) Usage() int64 {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if element, ok := c.items[key]; ok {
-		item := element.Value.(*cacheItem)
-		if item.entry.Expired() {
-			c.list.Remove(element)
-			delete(c.items, key)
-			return Entry{}, false
-		}
+	return c.used
+}
 
-		c.list.MoveToFront(element)
-		return item.entry, true
+func (c *MemoryCache) Get(key string, header http.Header) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok {
+		c.policy.Miss(key)
+		return Entry{}, false
+	}
+
+	if c.discard(item) {
+		return Entry{}, false
+	}
+
+	for _, entry := range item.variants {
+		if entry.Matches(header) {
+			c.policy.Hit(key)
+			return entry, true
+		}
 	}
 
 	return Entry{}, false
@@ -48,21 +78,159 @@ func (c *MemoryCache) Set(key string, entry Entry) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if element, ok := c.items[key]; ok {
-		c.list.MoveToFront(element)
-		element.Value.(*cacheItem).entry = entry
+	item, ok := c.items[key]
+
+	// An entry larger than the whole budget would only flush the cache, but
+	// it still supersedes the stored variants
+	if c.maxBytes > 0 && int64(len(key)+entry.Size()) > c.maxBytes {
+		if ok {
+			c.remove(item)
+		}
 		return
 	}
 
-	item := &cacheItem{key, entry}
-	element := c.list.PushFront(item)
-	c.items[key] = element
+	if ok {
+		c.untrack(item)
+		item.variants = append([]Entry{entry}, slices.DeleteFunc(item.variants, entry.supersedes)...)
+		c.track(item)
+		c.policy.Update(key, item.size)
+	} else {
+		item = &cacheItem{key: key, variants: []Entry{entry}}
+		c.items[key] = item
+		c.track(item)
+		c.policy.Add(key, item.size)
+	}
+
+	c.evict()
+}
+
+// discard removes the item's discardable variants, and the item itself when
+// none is left, in which case it returns true.
+func (c *MemoryCache) discard(item *cacheItem) bool {
+	if !slices.ContainsFunc(item.variants, Entry.Discardable) {
+		return false
+	}
+
+	c.untrack(item)
+	item.variants = slices.DeleteFunc(item.variants, Entry.Discardable)
+	if len(item.variants) == 0 {
+		delete(c.items, item.key)
+		c.policy.Remove(item.key)
+		return true
+	}
+
+	c.track(item)
+	c.policy.Resize(item.key, item.size)
+	return false
+}
+
+// evict removes the keys chosen by the eviction policy until the cache fits
+// in its budget. The key just stored may be one of them.
+func (c *MemoryCache) evict() {
+	for c.maxBytes > 0 && c.used > c.maxBytes {
+		key, ok := c.policy.Victim()
+		if !ok {
+			return
+		}
+
+		if item, ok := c.items[key]; ok {
+			c.untrack(item)
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if item, ok := c.items[key]; ok {
+		c.remove(item)
+	}
+}
+
+// Variants doesn't count as an access for the eviction policy.
+func (c *MemoryCache) Variants(key string) []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if item, ok := c.items[key]; ok {
+		return slices.Clone(item.variants)
+	}
+
+	return nil
+}
+
+// PurgeTag removes every key with a variant tagged with tag.
+func (c *MemoryCache) PurgeTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for key := range c.tags[tag] {
+		if item, ok := c.items[key]; ok {
+			c.remove(item)
+			purged++
+		}
+	}
+
+	return purged
+}
+
+func (c *MemoryCache) remove(item *cacheItem) {
+	c.untrack(item)
+	delete(c.items, item.key)
+	c.policy.Remove(item.key)
+}
+
+// track accounts for the item's size and tags, untrack must be called
+// before its variants are modified.
+func (c *MemoryCache) track(item *cacheItem) {
+	item.size = int64(len(item.key))
+	for _, entry := range item.variants {
+		item.size += int64(entry.Size())
+	}
+	c.used += item.size
+
+	for _, entry := range item.variants {
+		for _, tag := range entry.Tags() {
+			keys, ok := c.tags[tag]
+			if !ok {
+				keys = make(map[string]struct{})
+				c.tags[tag] = keys
+			}
+			keys[item.key] = struct{}{}
+		}
+	}
+}
+
+func (c *MemoryCache) untrack(item *cacheItem) {
+	c.used -= item.size
+
+	for _, entry := range item.variants {
+		for _, tag := range entry.Tags() {
+			delete(c.tags[tag], item.key)
+			if len(c.tags[tag]) == 0 {
+				delete(c.tags, tag)
+			}
+		}
+	}
+}
+
+// Range iterates over a snapshot, so fn may modify the cache.
+func (c *MemoryCache) Range(fn func(key string, entry Entry) bool) {
+	c.mu.Lock()
+	items := make([]cacheItem, 0, len(c.items))
+	for _, item := range c.items {
+		items = append(items, cacheItem{key: item.key, variants: slices.Clone(item.variants)})
+	}
+	c.mu.Unlock()
 
-	if c.list.Len() > c.capacity {
-		oldest := c.list.Back()
-		if oldest != nil {
-			c.list.Remove(oldest)
-			delete(c.items, oldest.Value.(*cacheItem).key)
+	for _, item := range items {
+		for _, entry := range item.variants {
+			if !fn(item.key, entry) {
+				return
+			}
 		}
 	}
 }
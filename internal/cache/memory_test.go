@@ -10,7 +10,7 @@ import (
 )
 
 func TestMemoryCache(t *testing.T) {
-	c := NewMemoryCache()
+	c := NewMemoryCache(0)
 
 	t.Run("Set and Get", func(t *testing.T) {
 		entry := Entry{
@@ -20,13 +20,13 @@ func TestMemoryCache(t *testing.T) {
 		}
 		c.Set("key1", entry)
 
-		got, ok := c.Get("key1")
+		got, ok := c.Get("key1", nil)
 		assert.True(t, ok)
 		assert.Equal(t, entry, got)
 	})
 
 	t.Run("Get missing", func(t *testing.T) {
-		_, ok := c.Get("missing")
+		_, ok := c.Get("missing", nil)
 		assert.False(t, ok)
 	})
 
@@ -38,7 +38,7 @@ func TestMemoryCache(t *testing.T) {
 		}
 		c.Set("expired_key", entry)
 
-		_, ok := c.Get("expired_key")
+		_, ok := c.Get("expired_key", nil)
 		assert.False(t, ok)
 
 		c.mu.Lock()
@@ -47,29 +47,210 @@ func TestMemoryCache(t *testing.T) {
 		assert.False(t, ok)
 	})
 
+	t.Run("Expired but revalidatable", func(t *testing.T) {
+		entry := Entry{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Etag": []string{`"v1"`}},
+			Body:       []byte("expired"),
+			ExpiresAt:  time.Now().Add(-1 * time.Hour),
+		}
+		c.Set("revalidate_key", entry)
+
+		got, ok := c.Get("revalidate_key", nil)
+		assert.True(t, ok)
+		assert.True(t, got.Expired())
+	})
+
+	t.Run("Expired within stale-while-revalidate", func(t *testing.T) {
+		c.Set("swr_key", Entry{
+			StatusCode:           http.StatusOK,
+			ExpiresAt:            time.Now().Add(-1 * time.Second),
+			StaleWhileRevalidate: 1 * time.Minute,
+		})
+
+		got, ok := c.Get("swr_key", nil)
+		assert.True(t, ok)
+		assert.True(t, got.Expired())
+
+		c.Set("swr_key", Entry{
+			StatusCode:           http.StatusOK,
+			ExpiresAt:            time.Now().Add(-2 * time.Minute),
+			StaleWhileRevalidate: 1 * time.Minute,
+		})
+
+		_, ok = c.Get("swr_key", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("Expired within stale-if-error", func(t *testing.T) {
+		c.Set("sie_key", Entry{
+			StatusCode:   http.StatusOK,
+			ExpiresAt:    time.Now().Add(-1 * time.Second),
+			StaleIfError: 1 * time.Minute,
+		})
+
+		got, ok := c.Get("sie_key", nil)
+		assert.True(t, ok)
+		assert.True(t, got.WithinStaleIfError(0))
+	})
+
+	t.Run("Vary variants", func(t *testing.T) {
+		c := NewMemoryCache(0)
+		respHeader := http.Header{"Vary": []string{"Accept-Language"}}
+		english := http.Header{"Accept-Language": []string{"en"}}
+		french := http.Header{"Accept-Language": []string{"fr"}}
+
+		c.Set("page", Entry{
+			Header:        respHeader,
+			Body:          []byte("hello"),
+			ExpiresAt:     time.Now().Add(1 * time.Hour),
+			RequestHeader: SelectingHeader(respHeader, english),
+		})
+		c.Set("page", Entry{
+			Header:        respHeader,
+			Body:          []byte("bonjour"),
+			ExpiresAt:     time.Now().Add(1 * time.Hour),
+			RequestHeader: SelectingHeader(respHeader, french),
+		})
+
+		got, ok := c.Get("page", english)
+		assert.True(t, ok)
+		assert.Equal(t, "hello", string(got.Body))
+
+		got, ok = c.Get("page", french)
+		assert.True(t, ok)
+		assert.Equal(t, "bonjour", string(got.Body))
+
+		_, ok = c.Get("page", http.Header{"Accept-Language": []string{"de"}})
+		assert.False(t, ok)
+
+		assert.Len(t, c.Variants("page"), 2)
+		assert.Len(t, Variants(NewTieredCache(NewMemoryCache(0), c, 0), "page"), 2)
+		assert.Empty(t, c.Variants("missing"))
+
+		// Replacing a variant keeps the others
+		c.Set("page", Entry{
+			Header:        respHeader,
+			Body:          []byte("hello again"),
+			ExpiresAt:     time.Now().Add(1 * time.Hour),
+			RequestHeader: SelectingHeader(respHeader, english),
+		})
+
+		got, _ = c.Get("page", english)
+		assert.Equal(t, "hello again", string(got.Body))
+		got, _ = c.Get("page", french)
+		assert.Equal(t, "bonjour", string(got.Body))
+
+		// A response with a different Vary supersedes all variants
+		c.Set("page", Entry{
+			Body:      []byte("no vary"),
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+		})
+
+		got, ok = c.Get("page", french)
+		assert.True(t, ok)
+		assert.Equal(t, "no vary", string(got.Body))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		c.Set("delete_key", Entry{ExpiresAt: time.Now().Add(1 * time.Hour)})
+		c.Delete("delete_key")
+		c.Delete("missing")
+
+		_, ok := c.Get("delete_key", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		c := NewMemoryCache(0)
+		c.Set("a", Entry{ExpiresAt: time.Now().Add(1 * time.Hour)})
+		c.Set("b", Entry{ExpiresAt: time.Now().Add(1 * time.Hour)})
+
+		// Deleting while iterating doesn't deadlock
+		var keys []string
+		c.Range(func(key string, _ Entry) bool {
+			keys = append(keys, key)
+			c.Delete(key)
+			return true
+		})
+		assert.ElementsMatch(t, []string{"a", "b"}, keys)
+
+		_, ok := c.Get("a", nil)
+		assert.False(t, ok)
+	})
+
 	t.Run("LRU Eviction", func(t *testing.T) {
-		c := NewMemoryCache()
-		// Fill it up to capacity (1000)
-		for i := 0; i < 1000; i++ {
-			c.Set(fmt.Sprintf("key%d", i), Entry{ExpiresAt: time.Now().Add(1 * time.Hour)})
+		// Each key holds 4 bytes of key and 100 bytes of body
+		c := NewMemoryCache(10 * 104)
+		body := make([]byte, 100)
+
+		// Fill it up to the budget
+		for i := range 10 {
+			c.Set(fmt.Sprintf("key%d", i), Entry{Body: body, ExpiresAt: time.Now().Add(1 * time.Hour)})
 		}
+		assert.Equal(t, int64(10*104), c.Usage())
 
 		// Access key0, so it's now the most recently used
-		c.Get("key0")
+		c.Get("key0", nil)
 
 		// Add one more, which should trigger eviction of key1 (the next oldest)
-		c.Set("new", Entry{ExpiresAt: time.Now().Add(1 * time.Hour)})
+		c.Set("new0", Entry{Body: body, ExpiresAt: time.Now().Add(1 * time.Hour)})
 
 		// key0 should still be there
-		_, ok := c.Get("key0")
+		_, ok := c.Get("key0", nil)
 		assert.True(t, ok)
 
 		// key1 should be gone
-		_, ok = c.Get("key1")
+		_, ok = c.Get("key1", nil)
 		assert.False(t, ok)
 
 		// newest one should be there
-		_, ok = c.Get("new")
+		_, ok = c.Get("new0", nil)
 		assert.True(t, ok)
+
+		// A large entry evicts as many keys as it needs
+		c.Set("big", Entry{Body: make([]byte, 300), ExpiresAt: time.Now().Add(1 * time.Hour)})
+		for _, key := range []string{"key2", "key3", "key4"} {
+			_, ok = c.Get(key, nil)
+			assert.False(t, ok, key)
+		}
+		_, ok = c.Get("key5", nil)
+		assert.True(t, ok)
+		assert.LessOrEqual(t, c.Usage(), int64(10*104))
+	})
+
+	t.Run("Entry larger than the budget", func(t *testing.T) {
+		c := NewMemoryCache(100)
+		c.Set("key", Entry{Body: []byte("small"), ExpiresAt: time.Now().Add(1 * time.Hour)})
+		c.Set("key", Entry{Body: make([]byte, 200), ExpiresAt: time.Now().Add(1 * time.Hour)})
+
+		// The stored entry is superseded even though the new one isn't stored
+		_, ok := c.Get("key", nil)
+		assert.False(t, ok)
+		assert.Zero(t, c.Usage())
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		c := NewMemoryCache(0)
+		entry := Entry{
+			Header:    http.Header{"Etag": []string{`"v1"`}},
+			Body:      []byte("body"),
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+		}
+
+		c.Set("key", entry)
+		assert.Equal(t, int64(len("key")+len("body")+len("Etag")+len(`"v1"`)), c.Usage())
+
+		// Replacing the entry doesn't count it twice
+		c.Set("key", entry)
+		assert.Equal(t, int64(len("key")+entry.Size()), c.Usage())
+
+		// Discarded variants are released
+		c.Set("expired", Entry{Body: []byte("expired"), ExpiresAt: time.Now().Add(-1 * time.Hour)})
+		c.Get("expired", nil)
+		assert.Equal(t, int64(len("key")+entry.Size()), c.Usage())
+
+		c.Delete("key")
+		assert.Zero(t, c.Usage())
 	})
 }
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+)
+
+// Policy holds the operator's defaults for responses that don't carry
+// explicit freshness information.
+type Policy struct {
+	// DefaultTTL applies when neither a status TTL nor a heuristic does.
+	DefaultTTL time.Duration
+	// MaxTTL caps every freshness lifetime. Zero means no cap.
+	MaxTTL time.Duration
+	// HeuristicFraction of the time since Last-Modified is used as the
+	// lifetime of responses without explicit freshness, see RFC 9111
+	// section 4.2.2. Zero disables the heuristic.
+	HeuristicFraction float64
+	// StatusTTLs sets the lifetime of responses without explicit freshness
+	// per status code. Listed statuses become cacheable even when they are
+	// not heuristically cacheable.
+	StatusTTLs map[int]time.Duration
+}
+
+var DefaultPolicy = Policy{
+	DefaultTTL:        defaultTTL,
+	HeuristicFraction: 0.1,
+}
+
+// defaultLifetime returns the lifetime of a response without explicit
+// freshness, or false when such a response must not be cached.
+func (p Policy) defaultLifetime(resp *http.Response, cc CacheControl, now time.Time) (time.Duration, bool) {
+	if ttl, ok := p.StatusTTLs[resp.StatusCode]; ok {
+		return ttl, true
+	}
+
+	if !cc.Has("public") && !heuristicallyCacheable[resp.StatusCode] {
+		return 0, false
+	}
+
+	if lifetime, ok := p.heuristicLifetime(resp.Header, now); ok {
+		return lifetime, true
+	}
+
+	return p.DefaultTTL, true
+}
+
+func (p Policy) heuristicLifetime(header http.Header, now time.Time) (time.Duration, bool) {
+	if p.HeuristicFraction <= 0 {
+		return 0, false
+	}
+
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return 0, false
+	}
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = now
+	}
+
+	return time.Duration(float64(max(0, date.Sub(lastModified))) * p.HeuristicFraction), true
+}
+
+func (p Policy) capLifetime(lifetime time.Duration) time.Duration {
+	if p.MaxTTL > 0 {
+		return min(lifetime, p.MaxTTL)
+	}
+
+	return lifetime
+}
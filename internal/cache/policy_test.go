@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyCanCacheResponse(t *testing.T) {
+	date := time.Now().UTC().Truncate(time.Second)
+	tenDaysAgo := date.Add(-10 * 24 * time.Hour)
+
+	policy := Policy{
+		DefaultTTL:        time.Minute,
+		MaxTTL:            2 * time.Hour,
+		HeuristicFraction: 0.1,
+		StatusTTLs: map[int]time.Duration{
+			http.StatusNotFound: 5 * time.Second,
+			http.StatusFound:    10 * time.Second,
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		policy      Policy
+		status      int
+		headers     http.Header
+		expectedTTL time.Duration
+		expectedOk  bool
+	}{
+		{
+			name:        "Default TTL",
+			policy:      policy,
+			status:      http.StatusOK,
+			headers:     http.Header{},
+			expectedTTL: time.Minute,
+			expectedOk:  true,
+		},
+		{
+			name:   "Heuristic from Last-Modified",
+			policy: policy,
+			status: http.StatusOK,
+			headers: http.Header{
+				"Date":          []string{date.Format(http.TimeFormat)},
+				"Last-Modified": []string{date.Add(-10 * time.Hour).Format(http.TimeFormat)},
+			},
+			expectedTTL: time.Hour,
+			expectedOk:  true,
+		},
+		{
+			name:   "Heuristic capped by MaxTTL",
+			policy: policy,
+			status: http.StatusOK,
+			headers: http.Header{
+				"Date":          []string{date.Format(http.TimeFormat)},
+				"Last-Modified": []string{tenDaysAgo.Format(http.TimeFormat)},
+			},
+			expectedTTL: 2 * time.Hour,
+			expectedOk:  true,
+		},
+		{
+			name: "Heuristic disabled",
+			policy: Policy{
+				DefaultTTL: time.Minute,
+			},
+			status: http.StatusOK,
+			headers: http.Header{
+				"Date":          []string{date.Format(http.TimeFormat)},
+				"Last-Modified": []string{tenDaysAgo.Format(http.TimeFormat)},
+			},
+			expectedTTL: time.Minute,
+			expectedOk:  true,
+		},
+		{
+			name:   "Explicit max-age capped by MaxTTL",
+			policy: policy,
+			status: http.StatusOK,
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=31536000"},
+			},
+			expectedTTL: 2 * time.Hour,
+			expectedOk:  true,
+		},
+		{
+			name:   "Explicit max-age wins over status TTL",
+			policy: policy,
+			status: http.StatusNotFound,
+			headers: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+			},
+			expectedTTL: time.Minute,
+			expectedOk:  true,
+		},
+		{
+			name:        "Status TTL",
+			policy:      policy,
+			status:      http.StatusNotFound,
+			headers:     http.Header{},
+			expectedTTL: 5 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name:        "Status TTL makes a status cacheable",
+			policy:      policy,
+			status:      http.StatusFound,
+			headers:     http.Header{},
+			expectedTTL: 10 * time.Second,
+			expectedOk:  true,
+		},
+		{
+			name:        "Unlisted status stays uncacheable",
+			policy:      policy,
+			status:      http.StatusTemporaryRedirect,
+			headers:     http.Header{},
+			expectedTTL: 0,
+			expectedOk:  false,
+		},
+		{
+			name:   "Expires",
+			policy: policy,
+			status: http.StatusOK,
+			headers: http.Header{
+				"Date":    []string{date.Format(http.TimeFormat)},
+				"Expires": []string{date.Add(30 * time.Minute).Format(http.TimeFormat)},
+			},
+			expectedTTL: 30 * time.Minute,
+			expectedOk:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tc.status,
+				Header:     tc.headers,
+			}
+			gotTTL, gotOk := tc.policy.CanCacheResponse(resp)
+			assert.InDelta(t, tc.expectedTTL.Seconds(), gotTTL.Seconds(), 1)
+			assert.Equal(t, tc.expectedOk, gotOk)
+		})
+	}
+}
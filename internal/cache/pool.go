@@ -0,0 +1,49 @@
+package cache
+
+import "io"
+
+// connPool keeps up to size idle connections to a remote backend.
+// Connections are dialed on demand, so more than size can be in use at once.
+type connPool[C io.Closer] struct {
+	dial func() (C, error)
+	idle chan C
+}
+
+func newConnPool[C io.Closer](size int, dial func() (C, error)) *connPool[C] {
+	return &connPool[C]{dial: dial, idle: make(chan C, max(size, 1))}
+}
+
+func (p *connPool[C]) get() (C, error) {
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+		return p.dial()
+	}
+}
+
+// put returns the connection to the pool, or closes it when it can't be
+// reused, after a network error left it in an unknown state.
+func (p *connPool[C]) put(c C, reuse bool) {
+	if !reuse {
+		c.Close()
+		return
+	}
+
+	select {
+	case p.idle <- c:
+	default:
+		c.Close()
+	}
+}
+
+func (p *connPool[C]) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxRanges bounds the number of ranges served from a single request, larger
+// requests are answered with the full body.
+const maxRanges = 64
+
+var (
+	errMalformedRange     = errors.New("malformed range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.start, br.start+br.length-1, size)
+}
+
+// parseRange parses a Range header value against a representation of the
+// given size, see RFC 9110 section 14.1.2. Ranges that don't overlap the
+// representation are dropped, and errUnsatisfiableRange is returned when none
+// is left.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	specs, ok := strings.CutPrefix(value, "bytes=")
+	if !ok {
+		return nil, errMalformedRange
+	}
+
+	var ranges []byteRange
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errMalformedRange
+		}
+
+		if first == "" {
+			// Suffix range, the last N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errMalformedRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+
+			n = min(n, size)
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, errMalformedRange
+		}
+
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, errMalformedRange
+			}
+			end = min(end, size-1)
+		}
+
+		if start >= size {
+			continue
+		}
+
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+
+	if len(ranges) > maxRanges {
+		return nil, errMalformedRange
+	}
+
+	return ranges, nil
+}
+
+// ifRangeMatches evaluates the If-Range precondition, which requires a
+// strong match with the entry's ETag or Last-Modified date.
+func ifRangeMatches(r *http.Request, entry Entry) bool {
+	value := r.Header.Get("If-Range")
+	if value == "" {
+		return true
+	}
+
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		etag := entry.Header.Get("ETag")
+		return !strings.HasPrefix(value, "W/") && !strings.HasPrefix(etag, "W/") && value == etag
+	}
+
+	lastModified := entry.Header.Get("Last-Modified")
+	return lastModified != "" && value == lastModified
+}
+
+// writeRanges serves the byte ranges requested from a complete cached
+// entry. It returns false when the full entry should be written instead.
+func writeRanges(w http.ResponseWriter, r *http.Request, entry Entry) bool {
+	value := r.Header.Get("Range")
+	if value == "" || r.Method != http.MethodGet || entry.StatusCode != http.StatusOK {
+		return false
+	}
+
+	if !ifRangeMatches(r, entry) {
+		return false
+	}
+
+	size := int64(len(entry.Body))
+	ranges, err := parseRange(value, size)
+	if errors.Is(err, errMalformedRange) {
+		return false
+	}
+
+	if errors.Is(err, errUnsatisfiableRange) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true
+	}
+
+	copyEntryHeader(w.Header(), entry)
+
+	if len(ranges) == 1 {
+		br := ranges[0]
+		w.Header().Set("Content-Range", br.contentRange(size))
+		w.Header().Set("Content-Length", strconv.FormatInt(br.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(entry.Body[br.start : br.start+br.length])
+		return true
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, br := range ranges {
+		part := textproto.MIMEHeader{}
+		if contentType := entry.Header.Get("Content-Type"); contentType != "" {
+			part.Set("Content-Type", contentType)
+		}
+		part.Set("Content-Range", br.contentRange(size))
+
+		pw, _ := mw.CreatePart(part)
+		pw.Write(entry.Body[br.start : br.start+br.length])
+	}
+	mw.Close()
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(body.Bytes())
+
+	return true
+}
@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		name        string
+		value       string
+		expected    []byteRange
+		expectedErr error
+	}{
+		{
+			name:     "First bytes",
+			value:    "bytes=0-9",
+			expected: []byteRange{{start: 0, length: 10}},
+		},
+		{
+			name:     "Open-ended",
+			value:    "bytes=90-",
+			expected: []byteRange{{start: 90, length: 10}},
+		},
+		{
+			name:     "Suffix",
+			value:    "bytes=-5",
+			expected: []byteRange{{start: 95, length: 5}},
+		},
+		{
+			name:     "Suffix larger than the representation",
+			value:    "bytes=-500",
+			expected: []byteRange{{start: 0, length: 100}},
+		},
+		{
+			name:     "End past the representation",
+			value:    "bytes=50-500",
+			expected: []byteRange{{start: 50, length: 50}},
+		},
+		{
+			name:     "Multiple ranges",
+			value:    "bytes=0-0, 10-19 ,-1",
+			expected: []byteRange{{start: 0, length: 1}, {start: 10, length: 10}, {start: 99, length: 1}},
+		},
+		{
+			name:     "Unsatisfiable range dropped",
+			value:    "bytes=0-4,200-300",
+			expected: []byteRange{{start: 0, length: 5}},
+		},
+		{
+			name:        "Unsatisfiable",
+			value:       "bytes=100-",
+			expectedErr: errUnsatisfiableRange,
+		},
+		{
+			name:        "Zero suffix",
+			value:       "bytes=-0",
+			expectedErr: errUnsatisfiableRange,
+		},
+		{
+			name:        "Unknown unit",
+			value:       "items=0-9",
+			expectedErr: errMalformedRange,
+		},
+		{
+			name:        "Reversed range",
+			value:       "bytes=9-0",
+			expectedErr: errMalformedRange,
+		},
+		{
+			name:        "Garbage",
+			value:       "bytes=a-b",
+			expectedErr: errMalformedRange,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseRange(tc.value, 100)
+			assert.Equal(t, tc.expectedErr, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
+
+func TestWriteCachedResponseRanges(t *testing.T) {
+	entry := Entry{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type":   []string{"text/plain"},
+			"Content-Length": []string{"26"},
+			"Etag":           []string{`"alphabet"`},
+			"Last-Modified":  []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+		},
+		Body: []byte("abcdefghijklmnopqrstuvwxyz"),
+	}
+
+	serve := func(headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		WriteCachedResponse(w, r, entry, "HIT")
+		return w
+	}
+
+	t.Run("Full response advertises ranges", func(t *testing.T) {
+		w := serve(nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	})
+
+	t.Run("Single range", func(t *testing.T) {
+		w := serve(map[string]string{"Range": "bytes=0-4"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "bytes 0-4/26", w.Header().Get("Content-Range"))
+		assert.Equal(t, "5", w.Header().Get("Content-Length"))
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, "abcde", w.Body.String())
+	})
+
+	t.Run("Multiple ranges", func(t *testing.T) {
+		w := serve(map[string]string{"Range": "bytes=0-1,-2"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+
+		mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		assert.NoError(t, err)
+		assert.Equal(t, "multipart/byteranges", mediaType)
+
+		mr := multipart.NewReader(w.Body, params["boundary"])
+		var parts []string
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+			body, _ := io.ReadAll(part)
+			parts = append(parts, part.Header.Get("Content-Range")+" "+part.Header.Get("Content-Type")+" "+string(body))
+		}
+		assert.Equal(t, []string{
+			"bytes 0-1/26 text/plain ab",
+			"bytes 24-25/26 text/plain yz",
+		}, parts)
+	})
+
+	t.Run("Unsatisfiable range", func(t *testing.T) {
+		w := serve(map[string]string{"Range": "bytes=26-"})
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, w.Code)
+		assert.Equal(t, "bytes */26", w.Header().Get("Content-Range"))
+	})
+
+	t.Run("Malformed range ignored", func(t *testing.T) {
+		w := serve(map[string]string{"Range": "bytes=z-"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 26, w.Body.Len())
+	})
+
+	t.Run("If-Range matching ETag", func(t *testing.T) {
+		w := serve(map[string]string{"Range": "bytes=-3", "If-Range": `"alphabet"`})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "xyz", w.Body.String())
+	})
+
+	t.Run("If-Range matching date", func(t *testing.T) {
+		w := serve(map[string]string{"Range": "bytes=-3", "If-Range": "Wed, 21 Oct 2015 07:28:00 GMT"})
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+	})
+
+	t.Run("If-Range mismatch serves the full body", func(t *testing.T) {
+		w := serve(map[string]string{"Range": "bytes=-3", "If-Range": `"other"`})
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, strings.HasPrefix(w.Body.String(), "abc"))
+	})
+
+	t.Run("If-Range weak tag never matches", func(t *testing.T) {
+		w := serve(map[string]string{"Range": "bytes=-3", "If-Range": `W/"alphabet"`})
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
@@ -0,0 +1,397 @@
+package cache
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errBreakerOpen = errors.New("circuit breaker open")
+
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	// PoolSize is the number of idle connections kept open.
+	PoolSize int
+	// Timeout bounds dialing and every round trip.
+	Timeout time.Duration
+	// Prefix namespaces the keys so several caches can share a database.
+	Prefix string
+	// Retention is how long expired entries that can be revalidated are
+	// kept, see retainUntil.
+	Retention time.Duration
+	// BreakerThreshold consecutive failures make the cache a bypass for
+	// BreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// RedisCache stores entries in a server speaking the RESP protocol, such as
+// Redis, Valkey or KeyDB, so several Cachefik instances can share them. The
+// variants of a key are stored together under a key expiring once none of
+// them can be used anymore. Tags are indexed with one key per tagged key,
+// expiring along with it. When the server is unreachable, the cache misses
+// and drops writes until the circuit breaker lets a trial request through.
+type RedisCache struct {
+	addr      string
+	pool      *connPool[*respConn]
+	breaker   *Breaker
+	prefix    string
+	retention time.Duration
+}
+
+func NewRedisCache(opts RedisOptions) *RedisCache {
+	if opts.BreakerThreshold <= 0 {
+		opts.BreakerThreshold = 5
+	}
+	if opts.BreakerCooldown <= 0 {
+		opts.BreakerCooldown = 10 * time.Second
+	}
+
+	return &RedisCache{
+		addr: opts.Addr,
+		pool: newConnPool(opts.PoolSize, func() (*respConn, error) {
+			return dialRESP(opts.Addr, opts.Password, opts.DB, opts.Timeout)
+		}),
+		breaker:   NewBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+		prefix:    opts.Prefix,
+		retention: opts.Retention,
+	}
+}
+
+func (c *RedisCache) entryKey(key string) string {
+	return c.prefix + "entry:" + key
+}
+
+// tagKey indexes key under tag. Tags can't contain spaces, see Tags.
+func (c *RedisCache) tagKey(tag, key string) string {
+	return c.prefix + "tag:" + tag + " " + key
+}
+
+// run calls fn with a pooled connection unless the circuit breaker is open.
+func (c *RedisCache) run(fn func(conn *respConn) error) error {
+	if !c.breaker.Allow() {
+		return errBreakerOpen
+	}
+
+	conn, err := c.pool.get()
+	if err == nil {
+		err = fn(conn)
+	}
+
+	// Error replies leave the connection usable and the server up
+	failure := err
+	var reply respError
+	if errors.As(err, &reply) {
+		failure = nil
+	}
+	if conn != nil {
+		c.pool.put(conn, failure == nil)
+	}
+	if c.breaker.Record(failure) {
+		slog.Warn("redis cache unavailable, bypassing it", "addr", c.addr, "error", err)
+	}
+
+	return err
+}
+
+// variants returns the variants stored under key, nil if the stored value
+// can't be decoded.
+func (c *RedisCache) variants(conn *respConn, key string) ([]Entry, error) {
+	reply, err := conn.do("GET", c.entryKey(key))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	data, _ := reply.([]byte)
+	variants, err := decodeVariants(data)
+	if err != nil {
+		slog.Warn("ignoring undecodable cache entry", "key", key, "error", err)
+		return nil, nil
+	}
+
+	return variants, nil
+}
+
+func (c *RedisCache) Get(key string, header http.Header) (Entry, bool) {
+	var variants []Entry
+	err := c.run(func(conn *respConn) (err error) {
+		variants, err = c.variants(conn, key)
+		return err
+	})
+	if err != nil {
+		return Entry{}, false
+	}
+
+	for _, entry := range variants {
+		if !entry.Discardable() && entry.Matches(header) {
+			return entry, true
+		}
+	}
+
+	return Entry{}, false
+}
+
+// Set merges the entry with the stored variants. Concurrent writes to the
+// same key from several instances may drop a variant, which only costs a
+// miss.
+func (c *RedisCache) Set(key string, entry Entry) {
+	entry.Tier = 0
+
+	c.run(func(conn *respConn) error {
+		stored, err := c.variants(conn, key)
+		if err != nil {
+			return err
+		}
+
+		variants := append([]Entry{entry}, slices.DeleteFunc(slices.Clone(stored), func(v Entry) bool {
+			return entry.supersedes(v) || v.Discardable()
+		})...)
+
+		var until time.Time
+		for _, v := range variants {
+			until = later(until, retainUntil(v, c.retention))
+		}
+
+		ttl := time.Until(until).Milliseconds()
+		if ttl <= 0 {
+			return pipelineErr(conn.pipeline(c.deleteCommands(key, stored)...))
+		}
+
+		cmds := [][]any{{"SET", c.entryKey(key), encodeVariants(variants), "PX", ttl}}
+		tags := variantTags(variants)
+		for _, tag := range tags {
+			cmds = append(cmds, []any{"SET", c.tagKey(tag, key), "", "PX", ttl})
+		}
+		// The replaced variants may leave tags the key doesn't carry anymore
+		for _, tag := range variantTags(stored) {
+			if !slices.Contains(tags, tag) {
+				cmds = append(cmds, []any{"DEL", c.tagKey(tag, key)})
+			}
+		}
+
+		return pipelineErr(conn.pipeline(cmds...))
+	})
+}
+
+// Delete removes the tag index keys of the stored variants along with them.
+func (c *RedisCache) Delete(key string) {
+	c.run(func(conn *respConn) error {
+		stored, err := c.variants(conn, key)
+		if err != nil {
+			return err
+		}
+
+		return pipelineErr(conn.pipeline(c.deleteCommands(key, stored)...))
+	})
+}
+
+// deleteCommands removes key and the tag index keys of its variants.
+func (c *RedisCache) deleteCommands(key string, variants []Entry) [][]any {
+	cmds := [][]any{{"DEL", c.entryKey(key)}}
+	for _, tag := range variantTags(variants) {
+		cmds = append(cmds, []any{"DEL", c.tagKey(tag, key)})
+	}
+
+	return cmds
+}
+
+func variantTags(variants []Entry) []string {
+	var tags []string
+	for _, v := range variants {
+		for _, tag := range v.Tags() {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
+
+func (c *RedisCache) Variants(key string) []Entry {
+	var variants []Entry
+	c.run(func(conn *respConn) (err error) {
+		variants, err = c.variants(conn, key)
+		return err
+	})
+
+	return variants
+}
+
+// Range scans the stored keys, so fn may modify the cache. Keys written
+// during the scan may or may not be visited.
+func (c *RedisCache) Range(fn func(key string, entry Entry) bool) {
+	prefix := c.entryKey("")
+	c.scan(prefix, func(keys []string) bool {
+		cmds := make([][]any, len(keys))
+		for i, key := range keys {
+			cmds[i] = []any{"GET", key}
+		}
+
+		var replies []any
+		err := c.run(func(conn *respConn) (err error) {
+			replies, err = conn.pipeline(cmds...)
+			return err
+		})
+		if err != nil {
+			return false
+		}
+
+		for i, reply := range replies {
+			data, ok := reply.([]byte)
+			if !ok {
+				continue
+			}
+
+			variants, err := decodeVariants(data)
+			if err != nil {
+				continue
+			}
+
+			for _, entry := range variants {
+				if !fn(strings.TrimPrefix(keys[i], prefix), entry) {
+					return false
+				}
+			}
+		}
+
+		return true
+	})
+}
+
+// PurgeTag removes every key with a variant tagged with tag. The index
+// may be behind the stored entries, so their tags are checked first.
+func (c *RedisCache) PurgeTag(tag string) int {
+	prefix := c.tagKey(tag, "")
+	purged := 0
+	c.scan(prefix, func(tagKeys []string) bool {
+		gets := make([][]any, len(tagKeys))
+		for i, tagKey := range tagKeys {
+			gets[i] = []any{"GET", c.entryKey(strings.TrimPrefix(tagKey, prefix))}
+		}
+
+		return c.run(func(conn *respConn) error {
+			replies, err := conn.pipeline(gets...)
+			if err != nil {
+				return err
+			}
+
+			var dels [][]any
+			for i, reply := range replies {
+				dels = append(dels, []any{"DEL", tagKeys[i]})
+
+				data, _ := reply.([]byte)
+				variants, err := decodeVariants(data)
+				if err == nil && slices.Contains(variantTags(variants), tag) {
+					dels = append(dels, c.deleteCommands(strings.TrimPrefix(tagKeys[i], prefix), variants)...)
+					purged++
+				}
+			}
+
+			return pipelineErr(conn.pipeline(dels...))
+		}) == nil
+	})
+
+	return purged
+}
+
+// scan calls fn with batches of the keys starting with prefix until fn
+// returns false.
+func (c *RedisCache) scan(prefix string, fn func(keys []string) bool) {
+	cursor := "0"
+	for {
+		var keys []string
+		err := c.run(func(conn *respConn) error {
+			reply, err := conn.do("SCAN", cursor, "MATCH", escapeGlob(prefix)+"*", "COUNT", 500)
+			if err != nil {
+				return err
+			}
+
+			page, _ := reply.([]any)
+			if len(page) != 2 {
+				return errors.New("malformed SCAN reply")
+			}
+
+			next, _ := page[0].([]byte)
+			cursor = string(next)
+			items, _ := page[1].([]any)
+			for _, item := range items {
+				if key, ok := item.([]byte); ok {
+					keys = append(keys, string(key))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+
+		if len(keys) > 0 && !fn(keys) {
+			return
+		}
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+func (c *RedisCache) Close() error {
+	c.pool.close()
+	return nil
+}
+
+// pipelineErr returns the pipeline's error or its first error reply.
+func pipelineErr(replies []any, err error) error {
+	if err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		if err, ok := reply.(respError); ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// escapeGlob escapes the characters special to the MATCH patterns.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// retainUntil returns when a remote store can drop the entry: once its stale
+// windows have passed, or retention after it expired when it can still be
+// revalidated.
+func retainUntil(e Entry, retention time.Duration) time.Time {
+	until := e.ExpiresAt
+	if !e.MustRevalidate {
+		until = until.Add(max(e.StaleWhileRevalidate, e.StaleIfError))
+	}
+	if e.Revalidatable() {
+		until = later(until, e.ExpiresAt.Add(retention))
+	}
+
+	return until
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
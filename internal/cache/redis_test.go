@@ -0,0 +1,389 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type respValue struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// respServer is an in-process stand-in for Redis implementing the commands
+// used by RedisCache.
+type respServer struct {
+	ln       net.Listener
+	password string
+
+	mu     sync.Mutex
+	values map[string]respValue
+}
+
+func newRESPServer(t *testing.T, password string) *respServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &respServer{ln: ln, password: password, values: make(map[string]respValue)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *respServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *respServer) ttl(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Until(s.values[key].expiresAt)
+}
+
+func (s *respServer) has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.values[key]
+	return ok
+}
+
+func (s *respServer) set(key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = respValue{data: data}
+}
+
+func (s *respServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	c := &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	authenticated := s.password == ""
+	for {
+		reply, err := c.readReply()
+		if err != nil {
+			return
+		}
+
+		var args []string
+		for _, arg := range reply.([]any) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		if strings.ToUpper(args[0]) == "AUTH" {
+			authenticated = args[1] == s.password
+		}
+		if !authenticated {
+			c.w.WriteString("-NOAUTH Authentication required.\r\n")
+		} else {
+			s.handle(c.w, args)
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *respServer) handle(w *bufio.Writer, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeBulk := func(v string) {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "AUTH", "SELECT", "PING":
+		w.WriteString("+OK\r\n")
+	case "GET":
+		v, ok := s.values[args[1]]
+		if !ok || (!v.expiresAt.IsZero() && time.Now().After(v.expiresAt)) {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		writeBulk(string(v.data))
+	case "SET":
+		v := respValue{data: []byte(args[2])}
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			v.expiresAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		s.values[args[1]] = v
+		w.WriteString("+OK\r\n")
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := s.values[key]; ok {
+				delete(s.values, key)
+				deleted++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", deleted)
+	case "SCAN":
+		// A single page, MATCH patterns are always an escaped prefix and *
+		prefix := strings.TrimSuffix(strings.ReplaceAll(args[3], `\`, ""), "*")
+		var keys []string
+		for key := range s.values {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		fmt.Fprintf(w, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+		for _, key := range keys {
+			writeBulk(key)
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func TestEncodeVariants(t *testing.T) {
+	variants := []Entry{
+		{
+			StatusCode:           http.StatusOK,
+			Header:               http.Header{"Content-Type": []string{"text/plain"}, "Vary": []string{"Accept-Encoding"}},
+			Body:                 []byte("cached content"),
+			ExpiresAt:            time.Now().Add(1 * time.Hour).Round(0),
+			RequestHeader:        http.Header{"Accept-Encoding": []string{"gzip"}},
+			StaleWhileRevalidate: 1 * time.Minute,
+			StaleIfError:         2 * time.Minute,
+			MustRevalidate:       true,
+			RequestTime:          time.Now().Add(-1 * time.Second).Round(0),
+			ResponseTime:         time.Now().Round(0),
+		},
+		{StatusCode: http.StatusNotFound, Body: []byte{}},
+	}
+
+	data := encodeVariants(variants)
+	got, err := decodeVariants(data)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, variants[0].Header, got[0].Header)
+	assert.Equal(t, variants[0].RequestHeader, got[0].RequestHeader)
+	assert.Equal(t, variants[0].Body, got[0].Body)
+	assert.True(t, variants[0].ExpiresAt.Equal(got[0].ExpiresAt))
+	assert.True(t, variants[0].ResponseTime.Equal(got[0].ResponseTime))
+	assert.Equal(t, variants[0].StaleWhileRevalidate, got[0].StaleWhileRevalidate)
+	assert.Equal(t, variants[0].StaleIfError, got[0].StaleIfError)
+	assert.True(t, got[0].MustRevalidate)
+	assert.Equal(t, http.StatusNotFound, got[1].StatusCode)
+	assert.True(t, got[1].ExpiresAt.IsZero())
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Empty", nil},
+		{"Unknown version", append([]byte{encodingVersion + 1}, data[1:]...)},
+		{"Truncated", data[:len(data)-3]},
+		{"Trailing data", append(data, 0)},
+		{"Huge count", []byte{encodingVersion, 0xff, 0xff, 0xff, 0x0f}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeVariants(tt.data)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(2, 50*time.Millisecond)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Record(assert.AnError))
+	assert.True(t, b.Allow())
+	assert.True(t, b.Record(assert.AnError), "the second failure opens the circuit")
+	assert.False(t, b.Allow())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, b.Allow(), "a trial goes through after the cooldown")
+	assert.False(t, b.Allow(), "only one trial at a time")
+	b.Record(nil)
+	assert.True(t, b.Allow())
+}
+
+func TestRedisCache(t *testing.T) {
+	entry := Entry{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/plain"}, "Surrogate-Key": []string{"home"}},
+		Body:       []byte("cached content"),
+		ExpiresAt:  time.Now().Add(1 * time.Hour),
+	}
+
+	newCache := func(t *testing.T, s *respServer) *RedisCache {
+		c := NewRedisCache(RedisOptions{
+			Addr:      s.addr(),
+			Password:  s.password,
+			Timeout:   1 * time.Second,
+			Prefix:    "test:",
+			Retention: 24 * time.Hour,
+		})
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	t.Run("Set and Get", func(t *testing.T) {
+		s := newRESPServer(t, "secret")
+		c := newCache(t, s)
+
+		c.Set("key", entry)
+		got, ok := c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, entry.Body, got.Body)
+		assert.Equal(t, entry.Header, got.Header)
+		assert.InDelta(t, time.Hour, s.ttl("test:entry:key"), float64(time.Second))
+		assert.Len(t, c.Variants("key"), 1)
+
+		_, ok = c.Get("missing", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("Vary", func(t *testing.T) {
+		c := newCache(t, newRESPServer(t, ""))
+
+		gzip := entry
+		gzip.Header = http.Header{"Vary": []string{"Accept-Encoding"}}
+		gzip.RequestHeader = http.Header{"Accept-Encoding": []string{"gzip"}}
+		gzip.Body = []byte("gzip")
+		identity := gzip
+		identity.RequestHeader = http.Header{}
+		identity.Body = []byte("identity")
+
+		c.Set("key", gzip)
+		c.Set("key", identity)
+
+		got, ok := c.Get("key", http.Header{"Accept-Encoding": []string{"gzip"}})
+		assert.True(t, ok)
+		assert.Equal(t, "gzip", string(got.Body))
+		got, ok = c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, "identity", string(got.Body))
+	})
+
+	t.Run("Retention", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			entry    Entry
+			expected time.Duration
+		}{
+			{"Fresh", Entry{ExpiresAt: time.Now().Add(1 * time.Minute)}, 1 * time.Minute},
+			{"Stale windows", Entry{ExpiresAt: time.Now().Add(1 * time.Minute), StaleIfError: 1 * time.Hour}, 61 * time.Minute},
+			{"Must revalidate", Entry{ExpiresAt: time.Now().Add(1 * time.Minute), StaleIfError: 1 * time.Hour, MustRevalidate: true}, 1 * time.Minute},
+			{"Revalidatable", Entry{Header: http.Header{"Etag": []string{`"v1"`}}, ExpiresAt: time.Now().Add(1 * time.Minute)}, 24*time.Hour + 1*time.Minute},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.WithinDuration(t, time.Now().Add(tt.expected), retainUntil(tt.entry, 24*time.Hour), time.Second)
+			})
+		}
+
+		s := newRESPServer(t, "")
+		c := newCache(t, s)
+		c.Set("key", entry)
+		c.Set("key", Entry{ExpiresAt: time.Now().Add(-1 * time.Minute)})
+		_, ok := c.Get("key", nil)
+		assert.False(t, ok, "a discardable entry deletes the key")
+	})
+
+	t.Run("Delete, Range and PurgeTag", func(t *testing.T) {
+		s := newRESPServer(t, "")
+		c := newCache(t, s)
+
+		c.Set("GET:http://example.com/a?", entry)
+		c.Set("GET:http://example.com/b?", entry)
+		c.Set("GET:http://example.com/c?", Entry{Body: []byte("untagged"), ExpiresAt: time.Now().Add(1 * time.Hour)})
+		s.set("other:entry:key", encodeVariants([]Entry{entry}))
+
+		var keys []string
+		c.Range(func(key string, _ Entry) bool {
+			keys = append(keys, key)
+			return true
+		})
+		assert.ElementsMatch(t, []string{"GET:http://example.com/a?", "GET:http://example.com/b?", "GET:http://example.com/c?"}, keys)
+
+		c.Delete("GET:http://example.com/a?")
+		_, ok := c.Get("GET:http://example.com/a?", nil)
+		assert.False(t, ok)
+		assert.False(t, s.has("test:tag:home GET:http://example.com/a?"), "Delete removes the tag index")
+
+		// Stored again without the tag, a stale index key must not purge it
+		s.set("test:tag:home GET:http://example.com/a?", nil)
+		c.Set("GET:http://example.com/a?", Entry{Body: []byte("untagged"), ExpiresAt: time.Now().Add(1 * time.Hour)})
+
+		// Replaced without the tag
+		c.Set("GET:http://example.com/d?", entry)
+		c.Set("GET:http://example.com/d?", Entry{Body: []byte("untagged"), ExpiresAt: time.Now().Add(1 * time.Hour)})
+		assert.False(t, s.has("test:tag:home GET:http://example.com/d?"))
+
+		assert.Equal(t, 1, PurgeTag(c, "home"))
+		_, ok = c.Get("GET:http://example.com/b?", nil)
+		assert.False(t, ok)
+		for _, key := range []string{"GET:http://example.com/a?", "GET:http://example.com/c?", "GET:http://example.com/d?"} {
+			_, ok = c.Get(key, nil)
+			assert.True(t, ok, key)
+		}
+		assert.False(t, s.has("test:tag:home GET:http://example.com/a?"))
+	})
+
+	t.Run("Undecodable entry", func(t *testing.T) {
+		s := newRESPServer(t, "")
+		c := newCache(t, s)
+
+		s.set("test:entry:key", []byte("garbage"))
+		_, ok := c.Get("key", nil)
+		assert.False(t, ok)
+
+		c.Set("key", entry)
+		_, ok = c.Get("key", nil)
+		assert.True(t, ok)
+	})
+
+	t.Run("Circuit breaker", func(t *testing.T) {
+		s := newRESPServer(t, "")
+		c := NewRedisCache(RedisOptions{
+			Addr:             s.addr(),
+			Timeout:          1 * time.Second,
+			BreakerThreshold: 2,
+			BreakerCooldown:  1 * time.Hour,
+		})
+		defer c.Close()
+
+		c.Set("key", entry)
+		_, ok := c.Get("key", nil)
+		assert.True(t, ok)
+
+		c.pool.close()
+		s.ln.Close()
+		for range 2 {
+			_, ok = c.Get("key", nil)
+			assert.False(t, ok)
+		}
+
+		assert.ErrorIs(t, c.run(func(*respConn) error { return nil }), errBreakerOpen)
+	})
+}
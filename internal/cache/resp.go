@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// respError is an error reply from the server. The connection is still
+// usable after one.
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// respConn speaks the RESP protocol used by Redis, Valkey and KeyDB.
+// Replies are returned as string, []byte, int64, []any or nil.
+type respConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+}
+
+// pipeline sends the commands in a single round trip and returns their
+// replies. An error reply is returned in place of the reply, not as err.
+func (c *respConn) pipeline(cmds ...[]any) ([]any, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+
+	for _, cmd := range cmds {
+		if err := c.writeCommand(cmd); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]any, len(cmds))
+	for i := range cmds {
+		reply, err := c.readReply()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+
+	return replies, nil
+}
+
+func (c *respConn) do(cmd ...any) (any, error) {
+	replies, err := c.pipeline(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err, ok := replies[0].(respError); ok {
+		return nil, err
+	}
+
+	return replies[0], nil
+}
+
+func (c *respConn) writeCommand(args []any) error {
+	c.w.WriteString("*")
+	c.w.WriteString(strconv.Itoa(len(args)))
+	c.w.WriteString("\r\n")
+
+	for _, arg := range args {
+		var b []byte
+		switch v := arg.(type) {
+		case []byte:
+			b = v
+		case string:
+			b = []byte(v)
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		default:
+			return fmt.Errorf("unsupported argument type %T", arg)
+		}
+
+		c.w.WriteString("$")
+		c.w.WriteString(strconv.Itoa(len(b)))
+		c.w.WriteString("\r\n")
+		c.w.Write(b)
+		if _, err := c.w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *respConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", errors.New("malformed RESP line")
+	}
+
+	return line[:len(line)-2], nil
+}
+
+func (c *respConn) readReply() (any, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected RESP reply %q", line)
+	}
+}
+
+func (c *respConn) Close() error {
+	return c.conn.Close()
+}
+
+// dialRESP opens a connection, authenticated and using db when set.
+func dialRESP(addr, password string, db int, timeout time.Duration) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &respConn{
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		timeout: timeout,
+	}
+
+	if password != "" {
+		if _, err := c.do("AUTH", password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if db != 0 {
+		if _, err := c.do("SELECT", db); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
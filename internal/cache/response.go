@@ -1,15 +1,82 @@
 package cache
 
-import "net/http"
+import (
+	"net/http"
+	"slices"
+	"strconv"
+)
 
-func WriteCachedResponse(w http.ResponseWriter, entry Entry) {
-	for k, vv := range entry.Header {
-		for _, v := range vv {
-			w.Header().Add(k, v)
+// Header fields sent along with a 304 Not Modified, see RFC 9110 section 15.4.5.
+var notModifiedHeaders = []string{
+	"Cache-Control",
+	"Content-Location",
+	"Date",
+	"Etag",
+	"Expires",
+	"Vary",
+}
+
+func WriteCachedResponse(w http.ResponseWriter, r *http.Request, entry Entry, status string) {
+	w.Header().Set("Age", strconv.FormatInt(int64(entry.Age().Seconds()), 10))
+	w.Header().Set("X-Cache", tierStatus(status, entry.Tier))
+
+	if NotModified(r, entry) {
+		for _, k := range notModifiedHeaders {
+			for _, v := range entry.Header.Values(k) {
+				w.Header().Add(k, v)
+			}
+		}
+
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if writeRanges(w, r, entry) {
+		return
+	}
+
+	copyEntryHeader(w.Header(), entry)
+	if entry.StatusCode == http.StatusOK && entry.Header.Get("Accept-Ranges") == "" {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	if r.Method == http.MethodHead {
+		if entry.StatusCode != http.StatusNoContent {
+			w.Header().Set("Content-Length", strconv.Itoa(len(entry.Body)))
 		}
+
+		w.WriteHeader(entry.StatusCode)
+		return
 	}
 
-	w.Header().Set("X-Cache", "HIT")
 	w.WriteHeader(entry.StatusCode)
 	w.Write(entry.Body)
 }
+
+// tierStatus appends the lookup result of every tier down to the one the
+// entry was found in, such as "HIT; L1=MISS; L2=HIT".
+func tierStatus(status string, tier int) string {
+	for i := 1; i <= tier; i++ {
+		result := "MISS"
+		if i == tier {
+			result = "HIT"
+		}
+		status += "; L" + strconv.Itoa(i) + "=" + result
+	}
+
+	return status
+}
+
+// copyEntryHeader adds the stored header, leaving the Age and X-Cache fields
+// set for the cached response untouched.
+func copyEntryHeader(header http.Header, entry Entry) {
+	for k, vv := range entry.Header {
+		if k == "Age" || k == "X-Cache" || slices.Contains(tagHeaders, k) {
+			continue
+		}
+
+		for _, v := range vv {
+			header.Add(k, v)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package cache
 import (
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,16 +14,107 @@ func TestWriteCachedResponse(t *testing.T) {
 	entry := Entry{
 		StatusCode: http.StatusOK,
 		Header: http.Header{
-			"Content-Type": []string{"text/plain"},
+			"Content-Type":  []string{"text/plain"},
+			"Surrogate-Key": []string{"home"},
 		},
 		Body: []byte("cached content"),
 	}
 
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
 	w := httptest.NewRecorder()
-	WriteCachedResponse(w, entry)
+	WriteCachedResponse(w, r, entry, "HIT")
 
 	assert.Equal(t, http.StatusOK, w.Code)
 	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Header().Get("Surrogate-Key"))
 	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
 	assert.Equal(t, "cached content", w.Body.String())
 }
+
+func TestWriteCachedResponseTier(t *testing.T) {
+	tests := []struct {
+		tier     int
+		expected string
+	}{
+		{0, "HIT"},
+		{1, "HIT; L1=HIT"},
+		{2, "HIT; L1=MISS; L2=HIT"},
+	}
+
+	for _, tt := range tests {
+		entry := Entry{StatusCode: http.StatusOK, Tier: tt.tier}
+		w := httptest.NewRecorder()
+		WriteCachedResponse(w, httptest.NewRequest(http.MethodGet, "/", nil), entry, "HIT")
+		assert.Equal(t, tt.expected, w.Header().Get("X-Cache"))
+	}
+}
+
+func TestWriteCachedResponseHead(t *testing.T) {
+	entry := Entry{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type": []string{"text/plain"},
+		},
+		Body: []byte("cached content"),
+	}
+
+	r := httptest.NewRequest(http.MethodHead, "/", nil)
+	w := httptest.NewRecorder()
+	WriteCachedResponse(w, r, entry, "HIT")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	assert.Equal(t, "14", w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+}
+
+func TestWriteCachedResponseAge(t *testing.T) {
+	now := time.Now()
+	entry := Entry{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Date": []string{now.Add(-30 * time.Second).UTC().Format(http.TimeFormat)},
+			"Age":  []string{"15"},
+		},
+		Body:         []byte("cached content"),
+		RequestTime:  now.Add(-30 * time.Second),
+		ResponseTime: now.Add(-30 * time.Second),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	WriteCachedResponse(w, r, entry, "HIT")
+
+	age, err := strconv.Atoi(w.Header().Get("Age"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 45, age, 1)
+	assert.Len(t, w.Header().Values("Age"), 1)
+}
+
+func TestWriteCachedResponseNotModified(t *testing.T) {
+	entry := Entry{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control":  []string{"max-age=60"},
+			"Content-Length": []string{"14"},
+			"Content-Type":   []string{"text/plain"},
+			"Etag":           []string{`"v1"`},
+			"Vary":           []string{"Accept-Encoding"},
+		},
+		Body: []byte("cached content"),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"v1"`)
+	w := httptest.NewRecorder()
+	WriteCachedResponse(w, r, entry, "HIT")
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, `"v1"`, w.Header().Get("ETag"))
+	assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Empty(t, w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"net/http"
+	"time"
+)
+
+// Header fields a 304 response must not overwrite in the stored response.
+var nonUpdatableHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Keep-Alive":        true,
+	"Proxy-Connection":  true,
+	"Te":                true,
+	"Trailer":           true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// Revalidatable reports whether the entry carries a validator that lets the
+// upstream confirm it with a 304 once it has expired.
+func (e Entry) Revalidatable() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// SetConditionalHeaders replaces any client preconditions on an upstream
+// request with the validators of the stored entry.
+func SetConditionalHeaders(header http.Header, entry Entry) {
+	header.Del("If-None-Match")
+	header.Del("If-Modified-Since")
+
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// Refresh returns a copy of the entry with its stored header, and the
+// directives derived from it, updated from a 304 Not Modified response to a
+// request sent at requestTime.
+func (e Entry) Refresh(header http.Header, requestTime time.Time) Entry {
+	merged := e.Header.Clone()
+	if merged == nil {
+		merged = make(http.Header)
+	}
+
+	for k, vv := range header {
+		if nonUpdatableHeaders[k] {
+			continue
+		}
+		merged[k] = append([]string(nil), vv...)
+	}
+
+	refreshed := e
+	refreshed.Header = StorableHeader(merged)
+	refreshed.RequestTime = requestTime
+	refreshed.ResponseTime = time.Now()
+	refreshed.applyCacheControl()
+
+	return refreshed
+}
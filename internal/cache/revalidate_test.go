@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevalidatable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		headers  http.Header
+		expected bool
+	}{
+		{
+			name:     "No validators",
+			headers:  http.Header{},
+			expected: false,
+		},
+		{
+			name: "ETag",
+			headers: http.Header{
+				"Etag": []string{`"v1"`},
+			},
+			expected: true,
+		},
+		{
+			name: "Last-Modified",
+			headers: http.Header{
+				"Last-Modified": []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Entry{Header: tc.headers}.Revalidatable())
+		})
+	}
+}
+
+func TestSetConditionalHeaders(t *testing.T) {
+	entry := Entry{
+		Header: http.Header{
+			"Etag":          []string{`"v1"`},
+			"Last-Modified": []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+		},
+	}
+
+	header := http.Header{
+		"If-None-Match": []string{`"client"`},
+	}
+	SetConditionalHeaders(header, entry)
+
+	assert.Equal(t, `"v1"`, header.Get("If-None-Match"))
+	assert.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", header.Get("If-Modified-Since"))
+
+	header = http.Header{
+		"If-Modified-Since": []string{"Thu, 01 Jan 2015 00:00:00 GMT"},
+	}
+	SetConditionalHeaders(header, Entry{Header: http.Header{"Etag": []string{`"v2"`}}})
+
+	assert.Equal(t, `"v2"`, header.Get("If-None-Match"))
+	assert.Empty(t, header.Get("If-Modified-Since"))
+}
+
+func TestEntryRefresh(t *testing.T) {
+	entry := Entry{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Cache-Control":  []string{"max-age=10"},
+			"Content-Length": []string{"4"},
+			"Content-Type":   []string{"application/json"},
+			"Etag":           []string{`"v1"`},
+		},
+		Body: []byte("body"),
+	}
+
+	requestTime := time.Now()
+	refreshed := entry.Refresh(http.Header{
+		"Cache-Control":  []string{"max-age=60"},
+		"Content-Length": []string{"0"},
+		"Date":           []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+	}, requestTime)
+
+	assert.Equal(t, "max-age=60", refreshed.Header.Get("Cache-Control"))
+	assert.Equal(t, "4", refreshed.Header.Get("Content-Length"))
+	assert.Equal(t, "application/json", refreshed.Header.Get("Content-Type"))
+	assert.Equal(t, "Wed, 21 Oct 2015 07:28:00 GMT", refreshed.Header.Get("Date"))
+	assert.Equal(t, "body", string(refreshed.Body))
+	assert.Equal(t, requestTime, refreshed.RequestTime)
+	assert.False(t, refreshed.ResponseTime.Before(requestTime))
+
+	// The stored header is left untouched
+	assert.Equal(t, "max-age=10", entry.Header.Get("Cache-Control"))
+	assert.Empty(t, entry.Header.Get("Date"))
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"hash/maphash"
+	"net/http"
+)
+
+// ShardedCache spreads keys over independent memory caches, each with its
+// own lock, so concurrent requests for different keys don't contend. Every
+// shard gets an equal part of the memory budget and evicts on its own.
+type ShardedCache struct {
+	seed   maphash.Seed
+	shards []*MemoryCache
+}
+
+// NewShardedCache returns a cache of n shards sharing maxBytes, see
+// NewMemoryCacheWithPolicy.
+func NewShardedCache(n int, maxBytes int64, policy EvictionPolicy) *ShardedCache {
+	n = max(n, 1)
+
+	c := &ShardedCache{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*MemoryCache, n),
+	}
+	for i := range c.shards {
+		c.shards[i] = NewMemoryCacheWithPolicy(maxBytes/int64(n), policy)
+	}
+
+	return c
+}
+
+func (c *ShardedCache) shard(key string) *MemoryCache {
+	return c.shards[maphash.String(c.seed, key)%uint64(len(c.shards))]
+}
+
+func (c *ShardedCache) Get(key string, header http.Header) (Entry, bool) {
+	return c.shard(key).Get(key, header)
+}
+
+func (c *ShardedCache) Set(key string, entry Entry) {
+	c.shard(key).Set(key, entry)
+}
+
+func (c *ShardedCache) Delete(key string) {
+	c.shard(key).Delete(key)
+}
+
+func (c *ShardedCache) Variants(key string) []Entry {
+	return c.shard(key).Variants(key)
+}
+
+func (c *ShardedCache) Range(fn func(key string, entry Entry) bool) {
+	for _, shard := range c.shards {
+		stopped := false
+		shard.Range(func(key string, entry Entry) bool {
+			stopped = !fn(key, entry)
+			return !stopped
+		})
+
+		if stopped {
+			return
+		}
+	}
+}
+
+func (c *ShardedCache) PurgeTag(tag string) int {
+	purged := 0
+	for _, shard := range c.shards {
+		purged += shard.PurgeTag(tag)
+	}
+
+	return purged
+}
+
+// Usage returns the total size of the stored entries in bytes.
+func (c *ShardedCache) Usage() int64 {
+	var used int64
+	for _, shard := range c.shards {
+		used += shard.Usage()
+	}
+
+	return used
+}
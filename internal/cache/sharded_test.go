@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedCache(t *testing.T) {
+	c := NewShardedCache(4, 0, EvictionLRU)
+	for i := range 100 {
+		c.Set(fmt.Sprintf("key%d", i), Entry{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Surrogate-Key": []string{fmt.Sprintf("group%d", i%2)}},
+			Body:       []byte("body"),
+			ExpiresAt:  time.Now().Add(1 * time.Hour),
+		})
+	}
+
+	// Keys are spread over the shards
+	for _, shard := range c.shards {
+		assert.NotZero(t, shard.Usage())
+	}
+
+	got, ok := c.Get("key42", nil)
+	assert.True(t, ok)
+	assert.Equal(t, "body", string(got.Body))
+
+	c.Delete("key42")
+	_, ok = c.Get("key42", nil)
+	assert.False(t, ok)
+
+	count := 0
+	c.Range(func(string, Entry) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 99, count)
+
+	// Range stops across shards
+	count = 0
+	c.Range(func(string, Entry) bool {
+		count++
+		return false
+	})
+	assert.Equal(t, 1, count)
+
+	assert.Equal(t, 49, PurgeTag(c, "group0"))
+
+	var used int64
+	c.Range(func(key string, entry Entry) bool {
+		used += int64(len(key) + entry.Size())
+		return true
+	})
+	assert.Equal(t, used, c.Usage())
+
+	// Each shard evicts within its part of the budget
+	c = NewShardedCache(4, 4*1000, EvictionLRU)
+	for i := range 100 {
+		c.Set(fmt.Sprintf("key%d", i), Entry{Body: make([]byte, 100), ExpiresAt: time.Now().Add(1 * time.Hour)})
+	}
+	for _, shard := range c.shards {
+		assert.LessOrEqual(t, shard.Usage(), int64(1000))
+	}
+}
+
+func TestShardedCacheConcurrency(t *testing.T) {
+	c := NewShardedCache(8, 64*1024, EvictionTinyLFU)
+
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				key := fmt.Sprintf("key%d", (g*i)%200)
+				if _, ok := c.Get(key, nil); !ok {
+					c.Set(key, Entry{Body: make([]byte, 100), ExpiresAt: time.Now().Add(1 * time.Hour)})
+				}
+				if i%100 == 0 {
+					c.Delete(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, c.Usage(), int64(64*1024))
+}
+
+// The parallel benchmarks measure the hit path from all cores at once, run
+// them with -cpu 1,2,4,8 to see how throughput scales with GOMAXPROCS.
+func benchmarkHits(b *testing.B, c Cache) {
+	keys := make([]string, 10_000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("GET:http://example.com/items/%d?", i)
+		c.Set(keys[i], Entry{Body: make([]byte, 1024), ExpiresAt: time.Now().Add(1 * time.Hour)})
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			if _, ok := c.Get(keys[r.Intn(len(keys))], nil); !ok {
+				b.Error("unexpected miss")
+			}
+		}
+	})
+}
+
+func BenchmarkMemoryCacheGetParallel(b *testing.B) {
+	benchmarkHits(b, NewMemoryCache(0))
+}
+
+func BenchmarkShardedCacheGetParallel(b *testing.B) {
+	for _, shards := range []int{4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchmarkHits(b, NewShardedCache(shards, 0, EvictionLRU))
+		})
+	}
+}
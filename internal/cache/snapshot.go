@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// snapshotVersion is bumped whenever the snapshot layout changes, older
+// snapshots are then ignored.
+const snapshotVersion = 1
+
+// maxSnapshotField bounds the allocations made for a corrupt record.
+const maxSnapshotField = 1 << 30
+
+var snapshotMagic = []byte("CACHEFIK-SNAPSHOT")
+
+// ErrSnapshotVersion is returned when loading a snapshot written in another
+// format.
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+// WriteSnapshot saves every unexpired entry of c to path, replacing it
+// atomically, and returns the number of entries written. A snapshot is a
+// header holding the format version followed by a record per variant: its
+// key and the variant, see encodeVariants. The entries keep their absolute
+// expiry times, so the time spent down counts towards their age.
+func WriteSnapshot(c Cache, path string) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-snapshot-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	w.Write(snapshotMagic)
+	w.WriteByte(snapshotVersion)
+
+	written := 0
+	c.Range(func(key string, entry Entry) bool {
+		if entry.Expired() {
+			return true
+		}
+
+		record := binary.AppendUvarint(nil, uint64(len(key)))
+		record = append(record, key...)
+		data := encodeVariants([]Entry{entry})
+		record = binary.AppendUvarint(record, uint64(len(data)))
+		if _, err = w.Write(append(record, data...)); err != nil {
+			return false
+		}
+
+		written++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	return written, os.Rename(f.Name(), path)
+}
+
+// LoadSnapshot stores the entries saved in path into c, skipping the ones
+// that expired since, and returns the number of entries loaded. A missing
+// snapshot loads nothing. Entries read before a corrupt record are kept.
+func LoadSnapshot(c Cache, path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) {
+		return 0, errors.New("not a cache snapshot")
+	}
+	if version := header[len(snapshotMagic)]; version != snapshotVersion {
+		return 0, fmt.Errorf("%w %d", ErrSnapshotVersion, version)
+	}
+
+	loaded := 0
+	for {
+		key, err := readSnapshotField(r)
+		if err == io.EOF {
+			return loaded, nil
+		}
+		if err != nil {
+			return loaded, err
+		}
+
+		data, err := readSnapshotField(r)
+		if err != nil {
+			return loaded, errTruncated
+		}
+
+		variants, err := decodeVariants(data)
+		if err != nil {
+			return loaded, err
+		}
+
+		for _, entry := range variants {
+			if !entry.Expired() {
+				c.Set(string(key), entry)
+				loaded++
+			}
+		}
+	}
+}
+
+// readSnapshotField reads a length prefixed field, io.EOF when there is none
+// left.
+func readSnapshotField(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil || n > maxSnapshotField {
+		return nil, errTruncated
+	}
+
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, errTruncated
+	}
+
+	return field, nil
+}
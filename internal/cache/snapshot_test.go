@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	fresh := Entry{
+		StatusCode:   http.StatusOK,
+		Header:       http.Header{"Content-Type": []string{"text/plain"}, "Surrogate-Key": []string{"home"}},
+		Body:         []byte("cached content"),
+		ExpiresAt:    time.Now().Add(1 * time.Hour).Round(0),
+		ResponseTime: time.Now().Add(-1 * time.Minute).Round(0),
+	}
+	gzip := fresh
+	gzip.Header = http.Header{"Vary": []string{"Accept-Encoding"}}
+	gzip.RequestHeader = http.Header{"Accept-Encoding": []string{"gzip"}}
+	identity := gzip
+	identity.RequestHeader = http.Header{}
+	identity.Body = []byte("identity")
+
+	c := NewMemoryCache(0)
+	c.Set("fresh", fresh)
+	c.Set("vary", gzip)
+	c.Set("vary", identity)
+	c.Set("expired", Entry{Body: []byte("old"), ExpiresAt: time.Now().Add(-1 * time.Minute), StaleIfError: 1 * time.Hour})
+
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+	written, err := WriteSnapshot(c, path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, written)
+
+	t.Run("Restore", func(t *testing.T) {
+		restored := NewMemoryCache(0)
+		loaded, err := LoadSnapshot(restored, path)
+		require.NoError(t, err)
+		assert.Equal(t, 3, loaded)
+
+		got, ok := restored.Get("fresh", nil)
+		assert.True(t, ok)
+		assert.Equal(t, fresh.Body, got.Body)
+		assert.True(t, fresh.ExpiresAt.Equal(got.ExpiresAt))
+		assert.True(t, fresh.ResponseTime.Equal(got.ResponseTime), "the age is preserved")
+		assert.Equal(t, []string{"home"}, got.Tags())
+
+		got, _ = restored.Get("vary", http.Header{"Accept-Encoding": []string{"gzip"}})
+		assert.Equal(t, "cached content", string(got.Body))
+		got, _ = restored.Get("vary", nil)
+		assert.Equal(t, "identity", string(got.Body))
+
+		_, ok = restored.Get("expired", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("Entries expiring while down are skipped", func(t *testing.T) {
+		short := NewMemoryCache(0)
+		short.Set("key", Entry{ExpiresAt: time.Now().Add(50 * time.Millisecond)})
+		shortPath := filepath.Join(t.TempDir(), "cache.snapshot")
+		_, err := WriteSnapshot(short, shortPath)
+		require.NoError(t, err)
+
+		time.Sleep(60 * time.Millisecond)
+		loaded, err := LoadSnapshot(NewMemoryCache(0), shortPath)
+		require.NoError(t, err)
+		assert.Zero(t, loaded)
+	})
+
+	t.Run("Missing snapshot", func(t *testing.T) {
+		loaded, err := LoadSnapshot(NewMemoryCache(0), filepath.Join(t.TempDir(), "missing"))
+		assert.NoError(t, err)
+		assert.Zero(t, loaded)
+	})
+
+	t.Run("Invalid snapshots", func(t *testing.T) {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		version := append([]byte{}, data...)
+		version[len(snapshotMagic)] = snapshotVersion + 1
+
+		tests := []struct {
+			name   string
+			data   []byte
+			loaded int
+			err    error
+		}{
+			{"Other version", version, 0, ErrSnapshotVersion},
+			{"Not a snapshot", []byte("garbage"), 0, nil},
+			{"Truncated", data[:len(data)-5], 2, nil},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				invalid := filepath.Join(t.TempDir(), "cache.snapshot")
+				require.NoError(t, os.WriteFile(invalid, tt.data, 0o644))
+
+				loaded, err := LoadSnapshot(NewMemoryCache(0), invalid)
+				assert.Error(t, err)
+				if tt.err != nil {
+					assert.ErrorIs(t, err, tt.err)
+				}
+				assert.Equal(t, tt.loaded, loaded)
+			})
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// tagHeaders name the response fields tagging an entry for invalidation.
+// They are meant for Cachefik only and never sent to clients.
+var tagHeaders = []string{"Surrogate-Key", "Cache-Tag"}
+
+// Tags returns the surrogate keys of a response. Surrogate-Key lists them
+// separated by spaces and Cache-Tag by commas, both are accepted.
+func Tags(header http.Header) []string {
+	var tags []string
+	for _, field := range tagHeaders {
+		for _, value := range header.Values(field) {
+			for _, tag := range strings.FieldsFunc(value, func(r rune) bool {
+				return r == ',' || r == ' ' || r == '\t'
+			}) {
+				if !slices.Contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+	}
+
+	return tags
+}
+
+// Tags returns the surrogate keys the entry was stored with.
+func (e Entry) Tags() []string {
+	return Tags(e.Header)
+}
+
+// RemoveTagHeaders strips the surrogate key fields from a client response.
+func RemoveTagHeaders(header http.Header) {
+	for _, field := range tagHeaders {
+		header.Del(field)
+	}
+}
+
+// TagPurger is implemented by caches indexing their entries by tag.
+type TagPurger interface {
+	// PurgeTag removes every key with a variant tagged with tag and returns
+	// how many keys were removed.
+	PurgeTag(tag string) int
+}
+
+// PurgeTag removes every key tagged with tag, through the cache's own index
+// when it has one.
+func PurgeTag(c Cache, tag string) int {
+	if p, ok := c.(TagPurger); ok {
+		return p.PurgeTag(tag)
+	}
+
+	var keys []string
+	c.Range(func(key string, entry Entry) bool {
+		if slices.Contains(entry.Tags(), tag) && !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	for _, key := range keys {
+		c.Delete(key)
+	}
+
+	return len(keys)
+}
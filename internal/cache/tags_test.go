@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTags(t *testing.T) {
+	testCases := []struct {
+		name     string
+		headers  http.Header
+		expected []string
+	}{
+		{
+			name:     "No tags",
+			headers:  http.Header{},
+			expected: nil,
+		},
+		{
+			name: "Surrogate-Key",
+			headers: http.Header{
+				"Surrogate-Key": []string{"product-1  category-shoes"},
+			},
+			expected: []string{"product-1", "category-shoes"},
+		},
+		{
+			name: "Cache-Tag",
+			headers: http.Header{
+				"Cache-Tag": []string{"product-1, category-shoes"},
+			},
+			expected: []string{"product-1", "category-shoes"},
+		},
+		{
+			name: "Both without duplicates",
+			headers: http.Header{
+				"Surrogate-Key": []string{"product-1 home"},
+				"Cache-Tag":     []string{"product-1,product-2"},
+			},
+			expected: []string{"product-1", "home", "product-2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Tags(tc.headers))
+		})
+	}
+}
+
+func TestPurgeTag(t *testing.T) {
+	tagged := func(keys string) Entry {
+		return Entry{
+			Header:    http.Header{"Surrogate-Key": []string{keys}},
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+		}
+	}
+
+	fill := func(c Cache) {
+		c.Set("/products/1", tagged("product-1"))
+		c.Set("/categories/shoes", tagged("product-1 product-2"))
+		c.Set("/", tagged("home product-1"))
+		c.Set("/products/2", tagged("product-2"))
+	}
+
+	// The wrapper hides MemoryCache's index to exercise the Range fallback
+	for name, c := range map[string]Cache{
+		"Indexed":  NewMemoryCache(0),
+		"Fallback": struct{ Cache }{NewMemoryCache(0)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fill(c)
+
+			assert.Equal(t, 3, PurgeTag(c, "product-1"))
+			assert.Equal(t, 0, PurgeTag(c, "product-1"))
+
+			_, ok := c.Get("/categories/shoes", nil)
+			assert.False(t, ok)
+			_, ok = c.Get("/products/2", nil)
+			assert.True(t, ok)
+		})
+	}
+
+	t.Run("Index follows replaced entries", func(t *testing.T) {
+		c := NewMemoryCache(0)
+		c.Set("/products/1", tagged("product-1"))
+		c.Set("/products/1", tagged("product-3"))
+
+		assert.Equal(t, 0, c.PurgeTag("product-1"))
+		assert.Equal(t, 1, c.PurgeTag("product-3"))
+
+		c.Set("/products/1", tagged("product-1"))
+		c.Delete("/products/1")
+		assert.Empty(t, c.tags)
+	})
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// TieredCache puts a small and fast L1 cache, typically in memory, in front
+// of a larger L2 store. Lookups fall back to L2 and promote its entries to
+// L1, writes go to both tiers.
+type TieredCache struct {
+	l1, l2 Cache
+	// maxL1Entry is the size above which entries are written through to L2
+	// only, zero or less means every entry goes to L1.
+	maxL1Entry int64
+}
+
+func NewTieredCache(l1, l2 Cache, maxL1Entry int64) *TieredCache {
+	return &TieredCache{l1: l1, l2: l2, maxL1Entry: maxL1Entry}
+}
+
+// Get sets the Tier of the returned entry to 1 or 2 depending on the tier
+// that served it. An expired L1 entry is only returned when L2 has nothing
+// fresher, e.g. written by another instance sharing it.
+func (c *TieredCache) Get(key string, header http.Header) (Entry, bool) {
+	l1Entry, inL1 := c.l1.Get(key, header)
+	if inL1 && !l1Entry.Expired() {
+		l1Entry.Tier = 1
+		return l1Entry, true
+	}
+
+	entry, ok := c.l2.Get(key, header)
+	if !ok || (inL1 && !entry.ExpiresAt.After(l1Entry.ExpiresAt)) {
+		if inL1 {
+			l1Entry.Tier = 1
+			return l1Entry, true
+		}
+		return Entry{}, false
+	}
+
+	if c.fitsL1(entry) {
+		c.l1.Set(key, entry)
+	}
+
+	entry.Tier = 2
+	return entry, true
+}
+
+func (c *TieredCache) Set(key string, entry Entry) {
+	entry.Tier = 0
+	c.l2.Set(key, entry)
+
+	if c.fitsL1(entry) {
+		c.l1.Set(key, entry)
+	} else {
+		// The variants left in L1 would shadow the new entry
+		c.l1.Delete(key)
+	}
+}
+
+func (c *TieredCache) fitsL1(entry Entry) bool {
+	return c.maxL1Entry <= 0 || int64(entry.Size()) <= c.maxL1Entry
+}
+
+func (c *TieredCache) Delete(key string) {
+	c.l1.Delete(key)
+	c.l2.Delete(key)
+}
+
+// Variants returns the L2 variants, or the L1 ones when L2 has none, like
+// Range.
+func (c *TieredCache) Variants(key string) []Entry {
+	if variants := Variants(c.l2, key); len(variants) > 0 {
+		return variants
+	}
+
+	return Variants(c.l1, key)
+}
+
+// CanList reports whether L2 can list its entries, L1 only holding some.
+func (c *TieredCache) CanList() bool {
+	return CanList(c.l2)
+}
+
+// Range visits the L2 entries, then the L1 entries under keys L2 doesn't
+// have anymore.
+func (c *TieredCache) Range(fn func(key string, entry Entry) bool) {
+	seen := make(map[string]struct{})
+	stopped := false
+	c.l2.Range(func(key string, entry Entry) bool {
+		seen[key] = struct{}{}
+		stopped = !fn(key, entry)
+		return !stopped
+	})
+
+	if stopped {
+		return
+	}
+
+	c.l1.Range(func(key string, entry Entry) bool {
+		if _, ok := seen[key]; ok {
+			return true
+		}
+
+		return fn(key, entry)
+	})
+}
+
+// RangeMetadata visits the tiers like Range.
+func (c *TieredCache) RangeMetadata(fn func(key string, entry Entry, size int) bool) {
+	seen := make(map[string]struct{})
+	stopped := false
+	RangeMetadata(c.l2, func(key string, entry Entry, size int) bool {
+		seen[key] = struct{}{}
+		stopped = !fn(key, entry, size)
+		return !stopped
+	})
+
+	if stopped {
+		return
+	}
+
+	RangeMetadata(c.l1, func(key string, entry Entry, size int) bool {
+		if _, ok := seen[key]; ok {
+			return true
+		}
+
+		return fn(key, entry, size)
+	})
+}
+
+// PurgeTag returns the number of keys purged from the tier holding the most.
+func (c *TieredCache) PurgeTag(tag string) int {
+	return max(PurgeTag(c.l1, tag), PurgeTag(c.l2, tag))
+}
+
+// Close closes the tiers that need it.
+func (c *TieredCache) Close() error {
+	var errs []error
+	for _, tier := range []Cache{c.l1, c.l2} {
+		if closer, ok := tier.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredCache(t *testing.T) {
+	entry := Entry{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Surrogate-Key": []string{"home"}},
+		Body:       []byte("cached content"),
+		ExpiresAt:  time.Now().Add(1 * time.Hour),
+	}
+
+	t.Run("Promote on L2 hit", func(t *testing.T) {
+		l1, l2 := NewMemoryCache(0), NewMemoryCache(0)
+		c := NewTieredCache(l1, l2, 0)
+
+		l2.Set("key", entry)
+		got, ok := c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, 2, got.Tier)
+
+		got, ok = c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, 1, got.Tier)
+
+		_, ok = c.Get("missing", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("Expired L1 entry", func(t *testing.T) {
+		l1, l2 := NewMemoryCache(0), NewMemoryCache(0)
+		c := NewTieredCache(l1, l2, 0)
+
+		// Kept in L1 as it can be revalidated
+		old := Entry{
+			Header:    http.Header{"Etag": []string{`"old"`}},
+			Body:      []byte("old"),
+			ExpiresAt: time.Now().Add(-1 * time.Minute),
+		}
+		l1.Set("key", old)
+		l2.Set("key", old)
+
+		got, ok := c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, "old", string(got.Body))
+		assert.Equal(t, 1, got.Tier)
+
+		// Refreshed in L2 by another instance
+		fresh := entry
+		fresh.Body = []byte("new")
+		l2.Set("key", fresh)
+
+		got, ok = c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, "new", string(got.Body))
+		assert.Equal(t, 2, got.Tier)
+
+		got, _ = c.Get("key", nil)
+		assert.Equal(t, "new", string(got.Body))
+		assert.Equal(t, 1, got.Tier, "the fresher entry is promoted")
+	})
+
+	t.Run("Write through above the L1 threshold", func(t *testing.T) {
+		l1, l2 := NewMemoryCache(0), NewMemoryCache(0)
+		c := NewTieredCache(l1, l2, int64(entry.Size()))
+
+		c.Set("small", entry)
+		_, ok := l1.Get("small", nil)
+		assert.True(t, ok)
+
+		large := entry
+		large.Body = make([]byte, 1024)
+		c.Set("large", large)
+		_, ok = l1.Get("large", nil)
+		assert.False(t, ok)
+
+		got, ok := c.Get("large", nil)
+		assert.True(t, ok)
+		assert.Equal(t, 2, got.Tier)
+		_, ok = l1.Get("large", nil)
+		assert.False(t, ok, "large entries are not promoted")
+
+		// A large replacement drops the L1 copy
+		c.Set("small", large)
+		got, _ = c.Get("small", nil)
+		assert.Len(t, got.Body, 1024)
+	})
+
+	t.Run("Delete, Range and PurgeTag", func(t *testing.T) {
+		l1, l2 := NewMemoryCache(0), NewMemoryCache(0)
+		c := NewTieredCache(l1, l2, 0)
+
+		c.Set("a", entry)
+		c.Set("b", entry)
+		l1.Set("c", entry)
+
+		keys := []string{}
+		c.Range(func(key string, _ Entry) bool {
+			keys = append(keys, key)
+			return true
+		})
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, keys)
+
+		c.Delete("a")
+		_, ok := l1.Get("a", nil)
+		assert.False(t, ok)
+		_, ok = l2.Get("a", nil)
+		assert.False(t, ok)
+
+		assert.Equal(t, 2, PurgeTag(c, "home"))
+		_, ok = c.Get("c", nil)
+		assert.False(t, ok)
+	})
+
+	t.Run("Disk L2", func(t *testing.T) {
+		dir := t.TempDir()
+		l2, err := NewDiskCache(dir, 0)
+		require.NoError(t, err)
+		c := NewTieredCache(NewMemoryCache(0), l2, 0)
+		c.Set("key", entry)
+		require.NoError(t, c.Close())
+
+		// A fresh L1 is filled from disk
+		l2, err = NewDiskCache(dir, 0)
+		require.NoError(t, err)
+		c = NewTieredCache(NewMemoryCache(0), l2, 0)
+		got, ok := c.Get("key", nil)
+		assert.True(t, ok)
+		assert.Equal(t, 2, got.Tier)
+		assert.Equal(t, entry.Body, got.Body)
+	})
+}
@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"hash/maphash"
+	"math/bits"
+)
+
+// tinyLFUPolicy implements W-TinyLFU: new keys enter a small LRU window,
+// and keys leaving the window are only admitted into the main segmented
+// LRU when a frequency sketch says they are requested more often than the
+// main space's victim. One-off requests, like a crawler scan, then go
+// through the window without flushing the frequently used keys.
+type tinyLFUPolicy struct {
+	window    *lruList
+	probation *lruList
+	protected *lruList
+	sketch    *countMinSketch
+
+	windowMax    int64
+	mainMax      int64
+	protectedMax int64
+}
+
+func newTinyLFUPolicy(maxBytes int64) *tinyLFUPolicy {
+	windowMax := maxBytes / 100
+	return &tinyLFUPolicy{
+		window:    newLRUList(),
+		probation: newLRUList(),
+		protected: newLRUList(),
+		// Sized assuming entries of 4KB on average
+		sketch:       newCountMinSketch(maxBytes / 4096),
+		windowMax:    windowMax,
+		mainMax:      maxBytes - windowMax,
+		protectedMax: (maxBytes - windowMax) * 8 / 10,
+	}
+}
+
+func (p *tinyLFUPolicy) Add(key string, size int64) {
+	p.window.pushFront(key, size)
+}
+
+func (p *tinyLFUPolicy) Update(key string, size int64) {
+	p.Resize(key, size)
+	p.touch(key)
+}
+
+func (p *tinyLFUPolicy) Resize(key string, size int64) {
+	for _, l := range []*lruList{p.window, p.probation, p.protected} {
+		l.resize(key, size)
+	}
+}
+
+func (p *tinyLFUPolicy) Hit(key string) {
+	p.sketch.increment(key)
+	p.touch(key)
+}
+
+func (p *tinyLFUPolicy) Miss(key string) {
+	p.sketch.increment(key)
+}
+
+func (p *tinyLFUPolicy) Remove(key string) {
+	for _, l := range []*lruList{p.window, p.probation, p.protected} {
+		if _, ok := l.remove(key); ok {
+			return
+		}
+	}
+}
+
+// touch moves a key to the front of its segment, promoting it from
+// probation to protected.
+func (p *tinyLFUPolicy) touch(key string) {
+	switch {
+	case p.window.contains(key):
+		p.window.moveToFront(key)
+	case p.protected.contains(key):
+		p.protected.moveToFront(key)
+	case p.probation.contains(key):
+		size, _ := p.probation.remove(key)
+		p.protected.pushFront(key, size)
+		for p.protected.bytes > p.protectedMax && p.protected.len() > 1 {
+			demoted, size, _ := p.protected.removeBack()
+			p.probation.pushFront(demoted, size)
+		}
+	}
+}
+
+func (p *tinyLFUPolicy) Victim() (string, bool) {
+	for p.window.bytes > p.windowMax {
+		candidate, _ := p.window.back()
+
+		victim, ok := p.probation.back()
+		if !ok {
+			victim, ok = p.protected.back()
+		}
+
+		// Until the main space is full, candidates are admitted for free
+		if !ok || p.probation.bytes+p.protected.bytes < p.mainMax {
+			size, _ := p.window.remove(candidate)
+			p.probation.pushFront(candidate, size)
+			continue
+		}
+
+		if p.sketch.estimate(candidate) <= p.sketch.estimate(victim) {
+			p.window.remove(candidate)
+			return candidate, true
+		}
+
+		size, _ := p.window.remove(candidate)
+		p.Remove(victim)
+		p.probation.pushFront(candidate, size)
+		return victim, true
+	}
+
+	for _, l := range []*lruList{p.probation, p.protected, p.window} {
+		if key, _, ok := l.removeBack(); ok {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// countMinSketch estimates how often keys were requested with 4 rows of
+// counters saturating at 15. Counters are halved every sample so the
+// estimates favour recent popularity.
+type countMinSketch struct {
+	seed      maphash.Seed
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+	sample    int
+}
+
+func newCountMinSketch(width int64) *countMinSketch {
+	width = min(max(width, 1024), 1<<20)
+	width = 1 << bits.Len64(uint64(width-1))
+
+	s := &countMinSketch{
+		seed:   maphash.MakeSeed(),
+		mask:   uint64(width - 1),
+		sample: 10 * int(width),
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+
+	return s
+}
+
+func (s *countMinSketch) indexes(key string) [4]uint64 {
+	h := maphash.String(s.seed, key)
+	h1, h2 := h&0xffffffff, h>>32|1
+
+	var indexes [4]uint64
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) & s.mask
+	}
+
+	return indexes
+}
+
+func (s *countMinSketch) increment(key string) {
+	for i, index := range s.indexes(key) {
+		if s.rows[i][index] < 15 {
+			s.rows[i][index]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.sample {
+		s.reset()
+	}
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	estimate := uint8(15)
+	for i, index := range s.indexes(key) {
+		estimate = min(estimate, s.rows[i][index])
+	}
+
+	return estimate
+}
+
+func (s *countMinSketch) reset() {
+	for _, row := range s.rows {
+		for i := range row {
+			row[i] /= 2
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// VaryFields returns the canonical request header names listed in the Vary
+// header of a response. A "*" member is returned as is.
+func VaryFields(header http.Header) []string {
+	var fields []string
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+
+			field = http.CanonicalHeaderKey(field)
+			if !slices.Contains(fields, field) {
+				fields = append(fields, field)
+			}
+		}
+	}
+
+	return fields
+}
+
+// SelectingHeader extracts the request header fields nominated by the
+// response's Vary header, normalized so they can be compared against later
+// requests.
+func SelectingHeader(respHeader, reqHeader http.Header) http.Header {
+	fields := VaryFields(respHeader)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	selected := make(http.Header, len(fields))
+	for _, field := range fields {
+		if value := normalizeFieldValue(reqHeader.Values(field)); value != "" {
+			selected.Set(field, value)
+		}
+	}
+
+	return selected
+}
+
+// Matches reports whether the entry is a suitable variant for a request
+// carrying the given header.
+func (e Entry) Matches(header http.Header) bool {
+	for _, field := range VaryFields(e.Header) {
+		if field == "*" {
+			return false
+		}
+
+		if normalizeFieldValue(header.Values(field)) != e.RequestHeader.Get(field) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func normalizeFieldValue(values []string) string {
+	var parts []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
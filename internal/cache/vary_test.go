@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaryFields(t *testing.T) {
+	testCases := []struct {
+		name     string
+		headers  http.Header
+		expected []string
+	}{
+		{
+			name:     "No Vary",
+			headers:  http.Header{},
+			expected: nil,
+		},
+		{
+			name: "Single field",
+			headers: http.Header{
+				"Vary": []string{"accept-encoding"},
+			},
+			expected: []string{"Accept-Encoding"},
+		},
+		{
+			name: "Multiple fields and values",
+			headers: http.Header{
+				"Vary": []string{"Accept-Encoding, Accept-Language", "accept-encoding,Origin"},
+			},
+			expected: []string{"Accept-Encoding", "Accept-Language", "Origin"},
+		},
+		{
+			name: "Wildcard",
+			headers: http.Header{
+				"Vary": []string{"*"},
+			},
+			expected: []string{"*"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, VaryFields(tc.headers))
+		})
+	}
+}
+
+func TestEntryMatches(t *testing.T) {
+	respHeader := http.Header{
+		"Vary": []string{"Accept-Encoding, Accept-Language"},
+	}
+	entry := Entry{
+		Header: respHeader,
+		RequestHeader: SelectingHeader(respHeader, http.Header{
+			"Accept-Encoding": []string{"gzip,  br"},
+		}),
+	}
+
+	testCases := []struct {
+		name     string
+		headers  http.Header
+		expected bool
+	}{
+		{
+			name: "Same values",
+			headers: http.Header{
+				"Accept-Encoding": []string{"gzip, br"},
+			},
+			expected: true,
+		},
+		{
+			name: "Same values split across lines",
+			headers: http.Header{
+				"Accept-Encoding": []string{"gzip", "br"},
+			},
+			expected: true,
+		},
+		{
+			name: "Different value",
+			headers: http.Header{
+				"Accept-Encoding": []string{"identity"},
+			},
+			expected: false,
+		},
+		{
+			name: "Extra selecting header",
+			headers: http.Header{
+				"Accept-Encoding": []string{"gzip, br"},
+				"Accept-Language": []string{"fr"},
+			},
+			expected: false,
+		},
+		{
+			name: "Unrelated header ignored",
+			headers: http.Header{
+				"Accept-Encoding": []string{"gzip, br"},
+				"User-Agent":      []string{"curl"},
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, entry.Matches(tc.headers))
+		})
+	}
+
+	t.Run("Wildcard never matches", func(t *testing.T) {
+		entry := Entry{Header: http.Header{"Vary": []string{"*"}}}
+		assert.False(t, entry.Matches(http.Header{}))
+	})
+}
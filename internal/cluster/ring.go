@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Ring assigns keys to peers by consistent hashing. Every peer is placed at
+// several points of the ring, its virtual nodes, and owns the keys hashing
+// up to each of them. Adding or removing a peer only moves the keys it gains
+// or loses, and the virtual nodes spread them evenly over the other peers.
+type Ring struct {
+	mu     sync.RWMutex
+	vnodes int
+	peers  []string
+	hashes []uint64
+	owners map[uint64]string
+}
+
+func NewRing(vnodes int) *Ring {
+	return &Ring{vnodes: max(vnodes, 1), owners: make(map[uint64]string)}
+}
+
+// Set replaces the peers on the ring.
+func (r *Ring) Set(peers []string) {
+	peers = slices.Clone(peers)
+	slices.Sort(peers)
+	peers = slices.Compact(peers)
+
+	hashes := make([]uint64, 0, len(peers)*r.vnodes)
+	owners := make(map[uint64]string, len(peers)*r.vnodes)
+	for _, peer := range peers {
+		for i := range r.vnodes {
+			h := hash(peer + "#" + strconv.Itoa(i))
+			// On the unlikely collision, the smallest peer wins everywhere
+			if _, ok := owners[h]; ok {
+				continue
+			}
+			owners[h] = peer
+			hashes = append(hashes, h)
+		}
+	}
+	slices.Sort(hashes)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.peers = peers
+	r.hashes = hashes
+	r.owners = owners
+}
+
+// Peers returns the peers on the ring, sorted.
+func (r *Ring) Peers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.peers)
+}
+
+// Owner returns the peer owning key, false when the ring is empty.
+func (r *Ring) Owner(key string) (string, bool) {
+	h := hash(key)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.hashes) == 0 {
+		return "", false
+	}
+
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.owners[r.hashes[i]], true
+}
+
+// hash must give the same result on every peer, unlike hash/maphash.
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
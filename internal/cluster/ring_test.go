@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func owners(r *Ring, keys []string) map[string]string {
+	owned := make(map[string]string, len(keys))
+	for _, key := range keys {
+		owned[key], _ = r.Owner(key)
+	}
+
+	return owned
+}
+
+func TestRing(t *testing.T) {
+	keys := make([]string, 10_000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("GET:http://example.com/items/%d?", i)
+	}
+
+	r := NewRing(128)
+	_, ok := r.Owner(keys[0])
+	assert.False(t, ok)
+
+	r.Set([]string{"10.0.0.3:8002", "10.0.0.1:8002", "10.0.0.2:8002", "10.0.0.1:8002"})
+	assert.Equal(t, []string{"10.0.0.1:8002", "10.0.0.2:8002", "10.0.0.3:8002"}, r.Peers())
+
+	before := owners(r, keys)
+
+	t.Run("Balance", func(t *testing.T) {
+		counts := make(map[string]int)
+		for _, owner := range before {
+			counts[owner]++
+		}
+
+		assert.Len(t, counts, 3)
+		for _, count := range counts {
+			assert.InDelta(t, len(keys)/3, count, float64(len(keys))/10)
+		}
+	})
+
+	t.Run("Same result on every peer", func(t *testing.T) {
+		other := NewRing(128)
+		other.Set([]string{"10.0.0.2:8002", "10.0.0.3:8002", "10.0.0.1:8002"})
+		assert.Equal(t, before, owners(other, keys))
+	})
+
+	t.Run("Adding a peer", func(t *testing.T) {
+		grown := NewRing(128)
+		grown.Set([]string{"10.0.0.1:8002", "10.0.0.2:8002", "10.0.0.3:8002", "10.0.0.4:8002"})
+
+		moved := 0
+		for key, owner := range owners(grown, keys) {
+			if owner != before[key] {
+				assert.Equal(t, "10.0.0.4:8002", owner, "keys only move to the new peer")
+				moved++
+			}
+		}
+		assert.InDelta(t, len(keys)/4, moved, float64(len(keys))/20)
+	})
+
+	t.Run("Removing a peer", func(t *testing.T) {
+		shrunk := NewRing(128)
+		shrunk.Set([]string{"10.0.0.1:8002", "10.0.0.3:8002"})
+
+		for key, owner := range owners(shrunk, keys) {
+			if before[key] != "10.0.0.2:8002" {
+				assert.Equal(t, before[key], owner, "only the removed peer's keys move")
+			}
+		}
+	})
+}
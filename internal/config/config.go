@@ -3,11 +3,14 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Addr          string
+	Addr string
+	// AdminAddr is where the cache admin API listens, disabled when empty
+	AdminAddr     string
 	ReadTimeout   time.Duration
 	WriteTimeout  time.Duration
 	ProxyTimeout  time.Duration
@@ -15,18 +18,110 @@ type Config struct {
 	DockerVersion string
 	LogLevel      string
 	MaxCacheSize  int64
+	// CacheBackend is one of memory, disk, redis, memcached or tiered
+	CacheBackend string
+	// CacheL2 is the backend behind the memory cache when tiered
+	CacheL2 string
+	// L1MaxEntry is the size above which entries skip the memory tier
+	L1MaxEntry int64
+	// CacheMemory is the memory cache budget in bytes
+	CacheMemory int64
+	// EvictionPolicy is one of lru, tinylfu or arc
+	EvictionPolicy string
+	// CacheShards splits the memory cache to reduce lock contention, 1 disables it
+	CacheShards int64
+	// JanitorInterval is how often expired entries are swept, 0 disables it
+	JanitorInterval time.Duration
+	// SnapshotPath is where the memory cache is saved on shutdown and
+	// restored from on startup, disabled when empty
+	SnapshotPath string
+	// WarmConcurrency is how many URLs cache warming requests at once
+	WarmConcurrency int64
+	// WarmRate is how many URLs cache warming requests per second, 0 for no limit
+	WarmRate float64
+	// DiskDir is where the disk cache stores its files
+	DiskDir string
+	// DiskSize is the disk cache budget in bytes
+	DiskSize      int64
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int64
+	MemcachedAddr string
+	// MemcachedItemSize is the server's item size limit, larger entries are chunked
+	MemcachedItemSize int64
+	// RemotePoolSize is the number of idle connections kept open to remote backends
+	RemotePoolSize int64
+	RemoteTimeout  time.Duration
+	// RemoteRetention is how long remote backends keep expired entries
+	// that can be revalidated
+	RemoteRetention time.Duration
+	// ClusterAddr is where the cluster endpoint listens, clustering is
+	// disabled when empty
+	ClusterAddr string
+	// ClusterSelf is this instance's address in the peer list
+	ClusterSelf string
+	// ClusterPeers is configured as "10.0.0.1:8002,10.0.0.2:8002"
+	ClusterPeers []string
+	// ClusterDiscovery is static or docker
+	ClusterDiscovery string
+	// ClusterRefresh is how often Docker peers are discovered again
+	ClusterRefresh time.Duration
+	// ClusterVnodes is the number of virtual nodes per peer on the hash ring
+	ClusterVnodes     int64
+	StaleIfError      time.Duration
+	CoalesceTimeout   time.Duration
+	DefaultTTL        time.Duration
+	MaxTTL            time.Duration
+	HeuristicFraction float64
+	// StatusTTLs is configured as "404=10s,301=1h"
+	StatusTTLs map[int]time.Duration
+	HeadFill   bool
 }
 
 func New() *Config {
 	return &Config{
-		Addr:          getEnv("CACHEFIK_ADDR", ":8000"),
-		ReadTimeout:   getDurationEnv("CACHEFIK_READ_TIMEOUT", 5*time.Second),
-		WriteTimeout:  getDurationEnv("CACHEFIK_WRITE_TIMEOUT", 10*time.Second),
-		ProxyTimeout:  getDurationEnv("CACHEFIK_PROXY_TIMEOUT", 10*time.Second),
-		MaxCacheSize:  getInt64Env("CACHEFIK_MAX_CACHE_SIZE", 10*1024*1024), // 10MB
-		DockerHost:    getEnv("CACHEFIK_DOCKER_HOST", ""),
-		DockerVersion: getEnv("CACHEFIK_DOCKER_VERSION", ""),
-		LogLevel:      getEnv("CACHEFIK_LOG_LEVEL", "info"),
+		Addr:              getEnv("CACHEFIK_ADDR", ":8000"),
+		AdminAddr:         getEnv("CACHEFIK_ADMIN_ADDR", ""),
+		ReadTimeout:       getDurationEnv("CACHEFIK_READ_TIMEOUT", 5*time.Second),
+		WriteTimeout:      getDurationEnv("CACHEFIK_WRITE_TIMEOUT", 10*time.Second),
+		ProxyTimeout:      getDurationEnv("CACHEFIK_PROXY_TIMEOUT", 10*time.Second),
+		MaxCacheSize:      getInt64Env("CACHEFIK_MAX_CACHE_SIZE", 10*1024*1024), // 10MB
+		CacheBackend:      getEnv("CACHEFIK_CACHE_BACKEND", "memory"),
+		CacheL2:           getEnv("CACHEFIK_CACHE_L2", "disk"),
+		L1MaxEntry:        getInt64Env("CACHEFIK_L1_MAX_ENTRY", 1024*1024),     // 1MB
+		CacheMemory:       getInt64Env("CACHEFIK_CACHE_MEMORY", 256*1024*1024), // 256MB
+		EvictionPolicy:    getEnv("CACHEFIK_EVICTION_POLICY", "lru"),
+		CacheShards:       getInt64Env("CACHEFIK_CACHE_SHARDS", 1),
+		JanitorInterval:   getDurationEnv("CACHEFIK_JANITOR_INTERVAL", time.Minute),
+		SnapshotPath:      getEnv("CACHEFIK_SNAPSHOT_PATH", ""),
+		WarmConcurrency:   getInt64Env("CACHEFIK_WARM_CONCURRENCY", 4),
+		WarmRate:          getFloat64Env("CACHEFIK_WARM_RATE", 10),
+		DiskDir:           getEnv("CACHEFIK_DISK_DIR", "/var/cache/cachefik"),
+		DiskSize:          getInt64Env("CACHEFIK_DISK_SIZE", 1024*1024*1024), // 1GB
+		RedisAddr:         getEnv("CACHEFIK_REDIS_ADDR", "localhost:6379"),
+		RedisPassword:     getEnv("CACHEFIK_REDIS_PASSWORD", ""),
+		RedisDB:           getInt64Env("CACHEFIK_REDIS_DB", 0),
+		MemcachedAddr:     getEnv("CACHEFIK_MEMCACHED_ADDR", "localhost:11211"),
+		MemcachedItemSize: getInt64Env("CACHEFIK_MEMCACHED_ITEM_SIZE", 1024*1024), // 1MB
+		RemotePoolSize:    getInt64Env("CACHEFIK_REMOTE_POOL_SIZE", 16),
+		RemoteTimeout:     getDurationEnv("CACHEFIK_REMOTE_TIMEOUT", 500*time.Millisecond),
+		RemoteRetention:   getDurationEnv("CACHEFIK_REMOTE_RETENTION", 24*time.Hour),
+		ClusterAddr:       getEnv("CACHEFIK_CLUSTER_ADDR", ""),
+		ClusterSelf:       getEnv("CACHEFIK_CLUSTER_SELF", ""),
+		ClusterPeers:      getListEnv("CACHEFIK_CLUSTER_PEERS"),
+		ClusterDiscovery:  getEnv("CACHEFIK_CLUSTER_DISCOVERY", "static"),
+		ClusterRefresh:    getDurationEnv("CACHEFIK_CLUSTER_REFRESH", 30*time.Second),
+		ClusterVnodes:     getInt64Env("CACHEFIK_CLUSTER_VNODES", 128),
+		StaleIfError:      getDurationEnv("CACHEFIK_STALE_IF_ERROR", 0),
+		CoalesceTimeout:   getDurationEnv("CACHEFIK_COALESCE_TIMEOUT", 5*time.Second),
+		DefaultTTL:        getDurationEnv("CACHEFIK_DEFAULT_TTL", 30*time.Second),
+		MaxTTL:            getDurationEnv("CACHEFIK_MAX_TTL", 0),
+		HeuristicFraction: getFloat64Env("CACHEFIK_HEURISTIC_FRACTION", 0.1),
+		StatusTTLs:        getStatusDurationsEnv("CACHEFIK_STATUS_TTLS"),
+		HeadFill:          getBoolEnv("CACHEFIK_HEAD_FILL", false),
+		DockerHost:        getEnv("CACHEFIK_DOCKER_HOST", ""),
+		DockerVersion:     getEnv("CACHEFIK_DOCKER_VERSION", ""),
+		LogLevel:          getEnv("CACHEFIK_LOG_LEVEL", "info"),
 	}
 }
 
@@ -65,3 +160,76 @@ func getInt64Env(key string, fallback int64) int64 {
 
 	return i
 }
+
+func getBoolEnv(key string, fallback bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+
+	return b
+}
+
+func getFloat64Env(key string, fallback float64) float64 {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fallback
+	}
+
+	return f
+}
+
+func getListEnv(key string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+func getStatusDurationsEnv(key string) map[int]time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return nil
+	}
+
+	durations := make(map[int]time.Duration)
+	for _, pair := range strings.Split(value, ",") {
+		status, duration, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			continue
+		}
+
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			continue
+		}
+
+		durations[code] = d
+	}
+
+	return durations
+}
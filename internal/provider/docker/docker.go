@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net"
+	"slices"
 	"sort"
 	"strconv"
 
@@ -12,6 +15,27 @@ import (
 )
 
 func DiscoverServices(ctx context.Context, host string, version string) ([]Service, error) {
+	cli, err := newClient(host, version)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	return listServices(ctx, cli)
+}
+
+// DiscoverPeers returns the Cachefik containers labeled as cluster peers.
+func DiscoverPeers(ctx context.Context, host string, version string) ([]Peer, error) {
+	cli, err := newClient(host, version)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	return listPeers(ctx, cli)
+}
+
+func newClient(host string, version string) (*client.Client, error) {
 	opts := []client.Opt{
 		client.FromEnv,
 	}
@@ -22,13 +46,7 @@ func DiscoverServices(ctx context.Context, host string, version string) ([]Servi
 		opts = append(opts, client.WithVersion(version))
 	}
 
-	cli, err := client.NewClientWithOpts(opts...)
-	if err != nil {
-		return nil, err
-	}
-	defer cli.Close()
-
-	return listServices(ctx, cli)
+	return client.NewClientWithOpts(opts...)
 }
 
 func listServices(ctx context.Context, cli client.ContainerAPIClient) ([]Service, error) {
@@ -61,16 +79,11 @@ func listServices(ctx context.Context, cli client.ContainerAPIClient) ([]Service
 			continue
 		}
 
-		if c.NetworkSettings == nil || len(c.NetworkSettings.Networks) == 0 {
+		ip, ok := containerIP(c, "")
+		if !ok {
 			continue
 		}
 
-		var ip string
-		for _, n := range c.NetworkSettings.Networks {
-			ip = n.IPAddress
-			break
-		}
-
 		upstream := fmt.Sprintf("http://%s:%d", ip, port)
 		slog.Debug("discovered service", "rule", rule, "upstream", upstream)
 		services = append(services, Service{
@@ -85,3 +98,51 @@ func listServices(ctx context.Context, cli client.ContainerAPIClient) ([]Service
 
 	return services, nil
 }
+
+func listPeers(ctx context.Context, cli client.ContainerAPIClient) ([]Peer, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []Peer
+	for _, c := range containers {
+		port, err := strconv.Atoi(c.Labels["cachefik.cluster.port"])
+		if err != nil {
+			continue
+		}
+
+		ip, ok := containerIP(c, c.Labels["cachefik.cluster.network"])
+		if !ok {
+			continue
+		}
+
+		peers = append(peers, Peer{
+			ContainerID: c.ID,
+			Addr:        net.JoinHostPort(ip, strconv.Itoa(port)),
+		})
+	}
+
+	return peers, nil
+}
+
+// containerIP returns the container's address on network, or on the first
+// of its networks by name so it doesn't change between calls.
+func containerIP(c container.Summary, network string) (string, bool) {
+	if c.NetworkSettings == nil {
+		return "", false
+	}
+
+	names := slices.Sorted(maps.Keys(c.NetworkSettings.Networks))
+	if network != "" {
+		names = []string{network}
+	}
+
+	for _, name := range names {
+		if n := c.NetworkSettings.Networks[name]; n != nil && n.IPAddress != "" {
+			return n.IPAddress, true
+		}
+	}
+
+	return "", false
+}
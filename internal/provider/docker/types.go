@@ -10,3 +10,10 @@ type Service struct {
 func (s Service) PathPrefix() string {
 	return strings.TrimSuffix(strings.TrimPrefix(s.Rule, "PathPrefix(`"), "`)")
 }
+
+// Peer is a Cachefik container taking part in the cache cluster.
+type Peer struct {
+	ContainerID string
+	// Addr is where the peer's cluster endpoint listens
+	Addr string
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Nelwhix/cachefik/internal/cache"
@@ -17,6 +20,10 @@ import (
 func main() {
 	cfg := config.New()
 
+	if len(os.Args) > 1 && os.Args[1] == "warm" {
+		os.Exit(runWarm(cfg, os.Args[2:]))
+	}
+
 	var level slog.Level
 	switch strings.ToLower(cfg.LogLevel) {
 	case "debug":
@@ -42,21 +49,104 @@ func main() {
 		os.Exit(1)
 	}
 
+	store, err := newCache(cfg)
+	if err != nil {
+		slog.Error("Cache setup failed", "error", err)
+		os.Exit(1)
+	}
+	defer closeCache(store)
+	restoreSnapshot(cfg, store)
+
 	handler := &Proxy{
 		Services: services,
 		Client: &http.Client{
 			Timeout: cfg.ProxyTimeout,
 		},
-		Cache:        cache.NewMemoryCache(),
-		MaxCacheSize: cfg.MaxCacheSize,
+		Cache:           store,
+		MaxCacheSize:    cfg.MaxCacheSize,
+		StaleIfError:    cfg.StaleIfError,
+		CoalesceTimeout: cfg.CoalesceTimeout,
+		HeadFill:        cfg.HeadFill,
+		Policy: &cache.Policy{
+			DefaultTTL:        cfg.DefaultTTL,
+			MaxTTL:            cfg.MaxTTL,
+			HeuristicFraction: cfg.HeuristicFraction,
+			StatusTTLs:        cfg.StatusTTLs,
+		},
 	}
 
-	server := &http.Server{
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var publicHandler http.Handler = handler
+	var servers []*http.Server
+	var peers *Cluster
+	if cfg.ClusterAddr != "" {
+		c, discover, err := newCluster(ctx, cfg, store)
+		if err != nil {
+			slog.Error("Cluster setup failed", "error", err)
+			os.Exit(1)
+		}
+
+		slog.Info("Starting cluster endpoint", "addr", cfg.ClusterAddr, "self", c.Self, "peers", c.Ring.Peers())
+		peers = c
+		handler.Cache = clusterCache{Cache: store, cluster: c}
+		publicHandler = c.Handler(handler)
+		servers = append(servers, &http.Server{
+			Addr:         cfg.ClusterAddr,
+			Handler:      c.PeerHandler(handler),
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		})
+
+		if discover != nil {
+			go c.WatchPeers(signalCtx, cfg.ClusterRefresh, discover)
+		}
+	}
+
+	servers = append(servers, &http.Server{
 		Addr:         cfg.Addr,
-		Handler:      handler,
+		Handler:      publicHandler,
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
+	})
+
+	if cfg.AdminAddr != "" {
+		slog.Info("Starting admin API", "addr", cfg.AdminAddr)
+		admin := NewAdmin(handler.Cache)
+		admin.Cluster = peers
+		admin.Warmer = &Warmer{
+			Handler:     publicHandler,
+			Concurrency: int(cfg.WarmConcurrency),
+			Rate:        cfg.WarmRate,
+		}
+		servers = append(servers, &http.Server{
+			Addr:         cfg.AdminAddr,
+			Handler:      admin,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		})
+	}
+
+	for _, server := range servers {
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	<-signalCtx.Done()
+
+	slog.Info("Shutting down")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.WriteTimeout)
+	defer cancelShutdown()
+
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Shutdown failed", "addr", server.Addr, "error", err)
+		}
 	}
 
-	log.Fatal(server.ListenAndServe())
+	saveSnapshot(cfg, store)
 }
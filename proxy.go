@@ -3,11 +3,13 @@ package main
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Nelwhix/cachefik/internal/cache"
@@ -19,48 +21,177 @@ type Proxy struct {
 	Client       *http.Client
 	Cache        cache.Cache
 	MaxCacheSize int64
+	// StaleIfError is a grace period during which expired entries are served
+	// when the upstream fails, on top of any stale-if-error directive.
+	StaleIfError time.Duration
+	// CoalesceTimeout is how long concurrent misses for the same key wait for
+	// the first request's upstream response. Zero disables coalescing.
+	CoalesceTimeout time.Duration
+	// HeadFill turns HEAD misses into GET requests upstream so the response
+	// can be cached for later requests.
+	HeadFill bool
+	// Policy sets the TTL defaults, cache.DefaultPolicy when nil.
+	Policy *cache.Policy
+
+	// refreshing tracks the keys with a background refresh in flight.
+	refreshing sync.Map
+	flights    flightGroup
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reportFill(r, p.serve(w, r))
+}
+
+// serve answers r from the cache, the response of a concurrent request or
+// the upstream, and returns what became of it.
+func (p *Proxy) serve(w http.ResponseWriter, r *http.Request) fill {
 	logger := slog.With("method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
 
-	if p.Cache != nil && cache.CanCacheRequest(r) {
-		key := cache.Key(r)
+	if p.Cache == nil || !cache.CanCacheRequest(r) {
+		return p.forward(w, r, logger, "", nil, nil)
+	}
+
+	key := cache.Key(r)
+	stale, served := p.serveFromCache(w, r, key)
+	if served {
+		return fill{result: WarmCached}
+	}
 
-		if entry, ok := p.Cache.Get(key); ok {
-			cache.WriteCachedResponse(w, entry)
-			return
-		}
+	// Range and HEAD requests are answered with partial content or no
+	// content at all, which can't be shared
+	if p.CoalesceTimeout <= 0 || r.Header.Get("Range") != "" || r.Method == http.MethodHead {
+		return p.forward(w, r, logger, key, stale, nil)
+	}
+
+	f, leader := p.flights.join(key, r.Header)
+	if leader {
+		defer p.flights.done(key, f)
+		return p.forward(w, r, logger, key, stale, f)
+	}
+
+	if p.serveFromFlight(w, r, f, logger) {
+		// Only cacheable responses are shared, the leader stores them
+		return fill{result: WarmCached}
+	}
+
+	// The leader's response could not be shared, but it may have refreshed the cache
+	stale, served = p.serveFromCache(w, r, key)
+	if served {
+		return fill{result: WarmCached}
 	}
 
+	return p.forward(w, r, logger, key, stale, nil)
+}
+
+// serveFromCache writes a fresh or stale-while-revalidate entry. Otherwise it
+// returns the expired entry, if any, for revalidation or stale-if-error.
+func (p *Proxy) serveFromCache(w http.ResponseWriter, r *http.Request, key string) (*cache.Entry, bool) {
+	entry, ok := p.Cache.Get(key, r.Header)
+	if !ok || !cache.CanServe(r.Header, entry.Header) {
+		return nil, false
+	}
+
+	if cache.RequiresRevalidation(r.Header) {
+		return &entry, false
+	}
+
+	if !entry.Expired() {
+		cache.WriteCachedResponse(w, r, entry, "HIT")
+		return nil, true
+	}
+
+	if entry.WithinStaleWhileRevalidate() {
+		p.refreshInBackground(r, key, entry)
+		cache.WriteCachedResponse(w, r, entry, "STALE")
+		return nil, true
+	}
+
+	return &entry, false
+}
+
+// forward proxies the request upstream. A non-empty key stores the response
+// in the cache when it is cacheable, and a non-nil flight shares it with the
+// requests waiting on the same key.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request, logger *slog.Logger, key string, stale *cache.Entry, f *flight) fill {
 	target := p.pickUpstream(r)
 	if target == "" {
 		logger.Warn("no upstream found")
 		sendJSONError(w, "no upstream found", http.StatusNotFound)
-		return
+		return fill{WarmFailed, "no upstream found"}
 	}
 
 	logger = logger.With("upstream", target)
 
 	upstreamURL, _ := url.Parse(target)
 	outRequest := p.cloneRequest(r, upstreamURL)
+	if r.Method == http.MethodHead && key != "" && p.HeadFill {
+		outRequest.Method = http.MethodGet
+	}
+
+	revalidate := stale != nil && stale.Revalidatable()
+	if revalidate {
+		cache.SetConditionalHeaders(outRequest.Header, *stale)
+	}
+
+	requestTime := time.Now()
 	resp, err := p.Client.Do(outRequest)
 	if err != nil {
+		if stale != nil && p.canServeStaleOnError(r, *stale) {
+			logger.Warn("upstream request failed, serving stale entry", "error", err)
+			cache.WriteCachedResponse(w, r, *stale, "STALE")
+			return fill{WarmFailed, err.Error()}
+		}
+
 		logger.Error("upstream request failed", "error", err)
 		sendJSONError(w, "upstream error", http.StatusInternalServerError)
-		return
+		return fill{WarmFailed, err.Error()}
 	}
 	defer resp.Body.Close()
 
-	ttl, ok := cache.CanCacheResponse(resp)
-	canCache := ok && p.Cache != nil && cache.CanCacheRequest(r)
+	if p.Cache != nil {
+		for _, k := range cache.InvalidationKeys(r, resp) {
+			p.Cache.Delete(k)
+		}
+	}
+
+	if resp.StatusCode >= http.StatusInternalServerError && stale != nil && p.canServeStaleOnError(r, *stale) {
+		logger.Warn("upstream returned an error, serving stale entry", "status", resp.StatusCode)
+		cache.WriteCachedResponse(w, r, *stale, "STALE")
+		return fill{WarmFailed, fmt.Sprintf("status %d", resp.StatusCode)}
+	}
 
-	var buf bytes.Buffer
+	if revalidate && resp.StatusCode == http.StatusNotModified {
+		return p.serveRevalidated(w, r, *stale, resp, requestTime)
+	}
+
+	// Only GET responses carry the body a cached entry needs
+	ttl, reason := p.policy().CheckResponse(resp)
+	canCache := reason == "" && key != "" && outRequest.Method == http.MethodGet
+	switch {
+	case key == "":
+		reason = "request not cacheable"
+	case outRequest.Method != http.MethodGet:
+		reason = "HEAD request"
+	}
+
+	var buf bodyBuffer = &bytes.Buffer{}
 	var bodyWriter = io.Discard
 	var lw *limitedWriter
+	if f != nil {
+		// A shared flight doubles as the cache buffer, so it is only shared
+		// when the whole body is known to fit: a body cut at MaxCacheSize
+		// would reach the waiters truncated. Bodies of unknown length are
+		// left to the cache instead.
+		shared := canCache && resp.ContentLength >= 0 && resp.ContentLength <= p.MaxCacheSize
+		f.publish(resp.StatusCode, resp.Header, shared, canCache && resp.ContentLength < 0)
+		if shared {
+			buf = f
+		}
+	}
+
 	if canCache {
 		lw = &limitedWriter{
-			W:     &buf,
+			W:     buf,
 			Limit: p.MaxCacheSize,
 		}
 		bodyWriter = lw
@@ -68,6 +199,7 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	copyHeaders(w.Header(), resp.Header)
 	removeHopByHopHeaders(w.Header())
+	cache.RemoveTagHeaders(w.Header())
 
 	if p.Cache != nil {
 		if canCache {
@@ -79,22 +211,159 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(resp.StatusCode)
 
+	var clientWriter io.Writer = w
+	if r.Method == http.MethodHead {
+		clientWriter = io.Discard
+	}
+
+	// A client going away doesn't stop a cacheable body from being read to
+	// the end, for the cache and the waiters
+	var cw *detachableWriter
+	if canCache {
+		cw = &detachableWriter{W: clientWriter}
+		clientWriter = cw
+	}
+
 	tee := io.TeeReader(resp.Body, bodyWriter)
-	_, err = io.Copy(w, tee)
+	_, err = io.Copy(clientWriter, tee)
 	if err != nil {
 		// Too late to send an error to the client as headers/status are already sent
 		logger.Error("streaming failed", "error", err)
-		return
+		if f != nil {
+			f.abort()
+		}
+		return fill{WarmFailed, err.Error()}
+	}
+	if cw != nil && cw.Err != nil {
+		logger.Error("streaming failed", "error", cw.Err)
 	}
 
 	if canCache && !lw.Exceeded {
-		p.Cache.Set(cache.Key(r), cache.Entry{
-			StatusCode: resp.StatusCode,
-			Header:     resp.Header,
-			Body:       buf.Bytes(),
-			ExpiresAt:  time.Now().Add(ttl),
-		})
+		p.storeResponse(key, cache.NewEntry(resp, r.Header, buf.Bytes(), ttl, requestTime))
+		return fill{result: WarmStored}
+	}
+
+	if canCache {
+		reason = "larger than the max cache size"
 	}
+	if f != nil {
+		f.abort()
+	}
+
+	return fill{WarmBypassed, reason}
+}
+
+func (p *Proxy) policy() cache.Policy {
+	if p.Policy == nil {
+		return cache.DefaultPolicy
+	}
+
+	return *p.Policy
+}
+
+func (p *Proxy) canServeStaleOnError(r *http.Request, stale cache.Entry) bool {
+	return stale.WithinStaleIfError(max(p.StaleIfError, cache.StaleIfError(r.Header)))
+}
+
+func (p *Proxy) serveRevalidated(w http.ResponseWriter, r *http.Request, stale cache.Entry, resp *http.Response, requestTime time.Time) fill {
+	entry, reason := p.storeRevalidated(cache.Key(r), stale, resp, requestTime)
+	cache.WriteCachedResponse(w, r, entry, "REVALIDATED")
+
+	if reason != "" {
+		return fill{WarmBypassed, reason}
+	}
+	return fill{result: WarmStored}
+}
+
+// refreshInBackground starts a single upstream refresh per key so a stale
+// entry can be served without waiting for the upstream.
+func (p *Proxy) refreshInBackground(r *http.Request, key string, stale cache.Entry) {
+	if _, busy := p.refreshing.LoadOrStore(key, struct{}{}); busy {
+		return
+	}
+
+	target := p.pickUpstream(r)
+	if target == "" {
+		p.refreshing.Delete(key)
+		return
+	}
+
+	// The incoming request must not be used once the handler has returned.
+	// The refresh always fetches the full representation.
+	upstreamURL, _ := url.Parse(target)
+	outRequest := p.cloneRequest(r, upstreamURL)
+	outRequest.Method = http.MethodGet
+	outRequest.Header.Del("Range")
+	outRequest.Header.Del("If-Range")
+	reqHeader := r.Header.Clone()
+
+	go func() {
+		defer p.refreshing.Delete(key)
+		p.refresh(key, outRequest, reqHeader, stale)
+	}()
+}
+
+func (p *Proxy) refresh(key string, outRequest *http.Request, reqHeader http.Header, stale cache.Entry) {
+	logger := slog.With("key", key, "upstream", outRequest.URL.Host)
+
+	if stale.Revalidatable() {
+		cache.SetConditionalHeaders(outRequest.Header, stale)
+	}
+
+	requestTime := time.Now()
+	resp, err := p.Client.Do(outRequest)
+	if err != nil {
+		logger.Error("background refresh failed", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if stale.Revalidatable() && resp.StatusCode == http.StatusNotModified {
+		p.storeRevalidated(key, stale, resp, requestTime)
+		return
+	}
+
+	ttl, ok := p.policy().CanCacheResponse(resp)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	lw := &limitedWriter{
+		W:     &buf,
+		Limit: p.MaxCacheSize,
+	}
+	if _, err := io.Copy(lw, resp.Body); err != nil {
+		logger.Error("background refresh failed", "error", err)
+		return
+	}
+
+	if !lw.Exceeded {
+		p.storeResponse(key, cache.NewEntry(resp, reqHeader, buf.Bytes(), ttl, requestTime))
+	}
+}
+
+func (p *Proxy) storeResponse(key string, entry cache.Entry) {
+	entry.StaleIfError = max(entry.StaleIfError, p.StaleIfError)
+	p.Cache.Set(key, entry)
+}
+
+// storeRevalidated applies a 304 Not Modified to the stale entry and stores
+// the result when it is still cacheable, returning the reason otherwise.
+func (p *Proxy) storeRevalidated(key string, stale cache.Entry, resp *http.Response, requestTime time.Time) (cache.Entry, string) {
+	entry := stale.Refresh(resp.Header, requestTime)
+
+	ttl, reason := p.policy().CheckResponse(&http.Response{
+		StatusCode: entry.StatusCode,
+		Header:     entry.Header,
+	})
+	if reason == "" {
+		entry.ExpiresAt = time.Now().Add(ttl)
+		entry.StaleIfError = max(entry.StaleIfError, p.StaleIfError)
+		p.Cache.Set(key, entry)
+	}
+
+	return entry, reason
 }
 
 func (p *Proxy) cloneRequest(r *http.Request, upstream *url.URL) *http.Request {
@@ -123,6 +392,26 @@ func (p *Proxy) pickUpstream(r *http.Request) string {
 	return ""
 }
 
+type bodyBuffer interface {
+	io.Writer
+	Bytes() []byte
+}
+
+// detachableWriter writes to W until a write fails, then discards the rest,
+// keeping the error in Err.
+type detachableWriter struct {
+	W   io.Writer
+	Err error
+}
+
+func (cw *detachableWriter) Write(p []byte) (int, error) {
+	if cw.Err == nil {
+		_, cw.Err = cw.W.Write(p)
+	}
+
+	return len(p), nil
+}
+
 type limitedWriter struct {
 	W        io.Writer
 	Limit    int64
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -28,7 +32,7 @@ func TestProxyIntegration(t *testing.T) {
 			{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
 		},
 		Client:       &http.Client{},
-		Cache:        cache.NewMemoryCache(),
+		Cache:        cache.NewMemoryCache(0),
 		MaxCacheSize: 1024 * 1024,
 	}
 
@@ -108,7 +112,7 @@ func TestProxyIntegration(t *testing.T) {
 				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
 			},
 			Client:       &http.Client{},
-			Cache:        cache.NewMemoryCache(),
+			Cache:        cache.NewMemoryCache(0),
 			MaxCacheSize: 1024 * 1024,
 		}
 
@@ -146,7 +150,7 @@ func TestProxyIntegration(t *testing.T) {
 				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
 			},
 			Client:       &http.Client{},
-			Cache:        cache.NewMemoryCache(),
+			Cache:        cache.NewMemoryCache(0),
 			MaxCacheSize: 1024 * 1024,
 		}
 
@@ -161,6 +165,572 @@ func TestProxyIntegration(t *testing.T) {
 		assert.Equal(t, "http", capturedHeader.Get("X-Forwarded-Proto"))
 	})
 
+	t.Run("Vary", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Vary", "Accept-Language")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("lang=" + r.Header.Get("Accept-Language")))
+		}))
+		defer backend.Close()
+
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        cache.NewMemoryCache(0),
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		get := func(lang string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/vary", nil)
+			req.Header.Set("Accept-Language", lang)
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, req)
+			return w
+		}
+
+		w := get("en")
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Equal(t, "lang=en", w.Body.String())
+
+		w = get("fr")
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Equal(t, "lang=fr", w.Body.String())
+
+		w = get("en")
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, "lang=en", w.Body.String())
+
+		w = get("fr")
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, "lang=fr", w.Body.String())
+	})
+
+	t.Run("Revalidation", func(t *testing.T) {
+		var fullFetches int
+		var conditional string
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conditional = r.Header.Get("If-None-Match")
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("ETag", `"v1"`)
+			if conditional == `"v1"` {
+				w.Header().Set("X-Refreshed", "true")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			fullFetches++
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("fresh content"))
+		}))
+		defer backend.Close()
+
+		c := cache.NewMemoryCache(0)
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        c,
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/revalidate", nil)
+		c.Set(cache.Key(req), cache.Entry{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Etag":         []string{`"v1"`},
+				"Content-Type": []string{"text/plain"},
+			},
+			Body:      []byte("stored content"),
+			ExpiresAt: time.Now().Add(-1 * time.Second),
+		})
+
+		// Expired entry is revalidated upstream
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "REVALIDATED", w.Header().Get("X-Cache"))
+		assert.Equal(t, "stored content", w.Body.String())
+		assert.Equal(t, "true", w.Header().Get("X-Refreshed"))
+		assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+		assert.Equal(t, `"v1"`, conditional)
+		assert.Equal(t, 0, fullFetches)
+
+		// Refreshed entry is fresh again
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, "stored content", w.Body.String())
+
+		// A changed resource replaces the stored entry
+		c.Set(cache.Key(req), cache.Entry{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Etag": []string{`"v0"`}},
+			Body:       []byte("old content"),
+			ExpiresAt:  time.Now().Add(-1 * time.Second),
+		})
+
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Equal(t, "fresh content", w.Body.String())
+		assert.Equal(t, `"v0"`, conditional)
+		assert.Equal(t, 1, fullFetches)
+	})
+
+	t.Run("Client Conditional Request", func(t *testing.T) {
+		var upstreamCalls int
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls++
+			w.Header().Set("ETag", `"asset-v1"`)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("asset"))
+		}))
+		defer backend.Close()
+
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        cache.NewMemoryCache(0),
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/asset.js", nil)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+
+		req = httptest.NewRequest(http.MethodGet, "/asset.js", nil)
+		req.Header.Set("If-None-Match", `W/"asset-v1"`)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, `"asset-v1"`, w.Header().Get("ETag"))
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, 1, upstreamCalls)
+	})
+
+	t.Run("Stale While Revalidate", func(t *testing.T) {
+		var upstreamCalls atomic.Int32
+		release := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			upstreamCalls.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60, stale-while-revalidate=30")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("refreshed content"))
+		}))
+		defer backend.Close()
+
+		c := cache.NewMemoryCache(0)
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        c,
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/swr", nil)
+		c.Set(cache.Key(req), cache.Entry{
+			StatusCode:           http.StatusOK,
+			Body:                 []byte("stale content"),
+			ExpiresAt:            time.Now().Add(-1 * time.Second),
+			StaleWhileRevalidate: 30 * time.Second,
+		})
+
+		// Stale entry is served immediately, twice, with a single refresh
+		for range 2 {
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, req)
+			assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+			assert.Equal(t, "stale content", w.Body.String())
+		}
+		close(release)
+
+		assert.Eventually(t, func() bool {
+			entry, ok := c.Get(cache.Key(req), req.Header)
+			return ok && !entry.Expired()
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(1), upstreamCalls.Load())
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, "refreshed content", w.Body.String())
+	})
+
+	t.Run("Stale If Error", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("restarting"))
+		}))
+		defer backend.Close()
+
+		c := cache.NewMemoryCache(0)
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        c,
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		// Response directive
+		req := httptest.NewRequest(http.MethodGet, "/sie-response", nil)
+		c.Set(cache.Key(req), cache.Entry{
+			StatusCode:   http.StatusOK,
+			Body:         []byte("stale content"),
+			ExpiresAt:    time.Now().Add(-1 * time.Second),
+			StaleIfError: 1 * time.Minute,
+		})
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+		assert.Equal(t, "stale content", w.Body.String())
+
+		// Request directive
+		req = httptest.NewRequest(http.MethodGet, "/sie-request", nil)
+		c.Set(cache.Key(req), cache.Entry{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Etag": []string{`"v1"`}},
+			Body:       []byte("stale content"),
+			ExpiresAt:  time.Now().Add(-10 * time.Second),
+		})
+
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "restarting", w.Body.String())
+
+		req.Header.Set("Cache-Control", "stale-if-error=60")
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+		assert.Equal(t, "stale content", w.Body.String())
+	})
+
+	t.Run("Stale If Error Grace Period", func(t *testing.T) {
+		c := cache.NewMemoryCache(0)
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: "http://localhost:1"},
+			},
+			Client: &http.Client{
+				Timeout: 100 * time.Millisecond,
+			},
+			Cache:        c,
+			MaxCacheSize: 1024 * 1024,
+			StaleIfError: 1 * time.Minute,
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/grace", nil)
+		c.Set(cache.Key(req), cache.Entry{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Last-Modified": []string{"Wed, 21 Oct 2015 07:28:00 GMT"}},
+			Body:       []byte("stale content"),
+			ExpiresAt:  time.Now().Add(-10 * time.Second),
+		})
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+		assert.Equal(t, "stale content", w.Body.String())
+	})
+
+	t.Run("Cache-Control Semantics", func(t *testing.T) {
+		var upstreamCalls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			switch r.URL.Path {
+			case "/public":
+				w.Header().Set("Cache-Control", "public, max-age=60")
+			case "/must-revalidate":
+				w.Header().Set("Cache-Control", "max-age=60, must-revalidate, stale-if-error=60")
+				if r.Header.Get("If-None-Match") != "" {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("content"))
+		}))
+		defer backend.Close()
+
+		c := cache.NewMemoryCache(0)
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        c,
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		// public allows sharing responses to authorized requests
+		for _, expected := range []string{"MISS", "HIT"} {
+			req := httptest.NewRequest(http.MethodGet, "/public", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, req)
+			assert.Equal(t, expected, w.Header().Get("X-Cache"))
+		}
+
+		// Request no-cache forces a trip to the upstream
+		upstreamCalls.Store(0)
+		req := httptest.NewRequest(http.MethodGet, "/public", nil)
+		req.Header.Set("Cache-Control", "no-cache")
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Equal(t, int32(1), upstreamCalls.Load())
+
+		// must-revalidate entries are never served stale
+		req = httptest.NewRequest(http.MethodGet, "/must-revalidate", nil)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+
+		entry, ok := c.Get(cache.Key(req), req.Header)
+		assert.True(t, ok)
+		assert.True(t, entry.MustRevalidate)
+		entry.ExpiresAt = time.Now().Add(-1 * time.Second)
+		c.Set(cache.Key(req), entry)
+
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("Age Header", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=600")
+			w.Header().Set("Age", "100")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("aged content"))
+		}))
+		defer backend.Close()
+
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        cache.NewMemoryCache(0),
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/aged", nil)
+		p.ServeHTTP(httptest.NewRecorder(), req)
+
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, req)
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, "100", w.Header().Get("Age"))
+		assert.NotEmpty(t, w.Header().Get("Date"))
+	})
+
+	t.Run("Range Requests", func(t *testing.T) {
+		var upstreamCalls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCalls.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			http.ServeContent(w, r, "video.mp4", time.Time{}, strings.NewReader("0123456789"))
+		}))
+		defer backend.Close()
+
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        cache.NewMemoryCache(0),
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		ranged := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/video.mp4", nil)
+			req.Header.Set("Range", "bytes=2-4")
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, req)
+			return w
+		}
+
+		// Partial upstream responses are passed through but never stored
+		w := ranged()
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "BYPASS", w.Header().Get("X-Cache"))
+		assert.Equal(t, "234", w.Body.String())
+
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/video.mp4", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Equal(t, "0123456789", w.Body.String())
+
+		// Ranges are then served from the complete entry
+		w = ranged()
+		assert.Equal(t, http.StatusPartialContent, w.Code)
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, "bytes 2-4/10", w.Header().Get("Content-Range"))
+		assert.Equal(t, "234", w.Body.String())
+		assert.Equal(t, int32(2), upstreamCalls.Load())
+	})
+
+	t.Run("HEAD Requests", func(t *testing.T) {
+		var methods []string
+		var mu sync.Mutex
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			methods = append(methods, r.Method)
+			mu.Unlock()
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte("full body"))
+		}))
+		defer backend.Close()
+
+		newProxy := func(headFill bool) *Proxy {
+			return &Proxy{
+				Services: []docker.Service{
+					{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+				},
+				Client:       &http.Client{},
+				Cache:        cache.NewMemoryCache(0),
+				MaxCacheSize: 1024 * 1024,
+				HeadFill:     headFill,
+			}
+		}
+
+		// Without fill, a HEAD miss is forwarded as is and not stored
+		p := newProxy(false)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/head", nil))
+		assert.Equal(t, "BYPASS", w.Header().Get("X-Cache"))
+
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/head", nil))
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/head", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, "9", w.Header().Get("Content-Length"))
+		assert.Empty(t, w.Body.String())
+
+		// With fill, the HEAD miss is fetched with GET and serves later requests
+		p = newProxy(true)
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "/head", nil))
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Empty(t, w.Body.String())
+
+		w = httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/head", nil))
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Equal(t, "full body", w.Body.String())
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []string{http.MethodHead, http.MethodGet, http.MethodGet}, methods)
+	})
+
+	t.Run("Invalidation on Unsafe Methods", func(t *testing.T) {
+		var version atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost:
+				version.Add(1)
+				w.Header().Set("Location", "/items/42")
+				w.WriteHeader(http.StatusCreated)
+			case http.MethodPut:
+				w.WriteHeader(http.StatusBadRequest)
+			default:
+				w.Header().Set("Cache-Control", "max-age=60")
+				fmt.Fprintf(w, "%s v%d", r.URL.Path, version.Load())
+			}
+		}))
+		defer backend.Close()
+
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        cache.NewMemoryCache(0),
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		do := func(method, path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+			return w
+		}
+
+		do(http.MethodGet, "/items")
+		do(http.MethodGet, "/items/42")
+		assert.Equal(t, "HIT", do(http.MethodGet, "/items").Header().Get("X-Cache"))
+
+		// A failed write leaves the cache alone
+		do(http.MethodPut, "/items")
+		assert.Equal(t, "HIT", do(http.MethodGet, "/items").Header().Get("X-Cache"))
+
+		// A successful write evicts the target and its Location
+		do(http.MethodPost, "/items")
+
+		w := do(http.MethodGet, "/items")
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Equal(t, "/items v1", w.Body.String())
+
+		w = do(http.MethodGet, "/items/42")
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Equal(t, "/items/42 v1", w.Body.String())
+	})
+
+	t.Run("Surrogate Keys", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Surrogate-Key", "product-1")
+			w.Write([]byte("product"))
+		}))
+		defer backend.Close()
+
+		c := cache.NewMemoryCache(0)
+		p := &Proxy{
+			Services: []docker.Service{
+				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
+			},
+			Client:       &http.Client{},
+			Cache:        c,
+			MaxCacheSize: 1024 * 1024,
+		}
+
+		get := func() *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/products/1", nil))
+			return w
+		}
+
+		w := get()
+		assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+		assert.Empty(t, w.Header().Get("Surrogate-Key"))
+
+		w = get()
+		assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+		assert.Empty(t, w.Header().Get("Surrogate-Key"))
+
+		assert.Equal(t, 1, cache.PurgeTag(c, "product-1"))
+		assert.Equal(t, "MISS", get().Header().Get("X-Cache"))
+	})
+
 	t.Run("Large Response (No Cache)", func(t *testing.T) {
 		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusOK)
@@ -173,7 +743,7 @@ func TestProxyIntegration(t *testing.T) {
 				{Rule: "PathPrefix(`/`)", Upstream: backend.URL},
 			},
 			Client:       &http.Client{},
-			Cache:        cache.NewMemoryCache(),
+			Cache:        cache.NewMemoryCache(0),
 			MaxCacheSize: 1000, // Limit 1000 bytes
 		}
 
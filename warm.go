@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nelwhix/cachefik/internal/config"
+)
+
+// Warming results, see WarmResult.
+const (
+	WarmStored   = "stored"
+	WarmCached   = "cached"
+	WarmBypassed = "bypassed"
+	WarmFailed   = "failed"
+)
+
+// maxSitemapSize is the sitemap protocol's limit on uncompressed sitemaps.
+const maxSitemapSize = 50 * 1024 * 1024
+
+// maxSitemapDepth bounds how deep sitemap indexes are followed.
+const maxSitemapDepth = 3
+
+// maxWarmConcurrency bounds how many URLs are requested at once.
+const maxWarmConcurrency = 64
+
+type WarmResult struct {
+	URL    string `json:"url"`
+	Result string `json:"result"`
+	Status int    `json:"status,omitempty"`
+	// Reason explains bypassed and failed URLs
+	Reason string `json:"reason,omitempty"`
+}
+
+type warmResultKey struct{}
+
+// fill is what the proxy did with the response to a request, a warming
+// result and its reason.
+type fill struct {
+	result string
+	reason string
+}
+
+// reportFill records f when r is a warming request.
+func reportFill(r *http.Request, f fill) {
+	if res, ok := r.Context().Value(warmResultKey{}).(*WarmResult); ok {
+		res.Result = f.result
+		res.Reason = f.reason
+	}
+}
+
+// Warmer fills the cache by requesting URLs through Handler, the proxy's
+// own routing and cache path, so they are stored as if clients had asked.
+type Warmer struct {
+	Handler http.Handler
+	// Concurrency is how many URLs are requested at once, up to
+	// maxWarmConcurrency.
+	Concurrency int
+	// Rate is the number of URLs requested per second, unlimited when 0.
+	Rate float64
+}
+
+// Warm requests every URL and calls report with each result as it
+// completes, one at a time. It stops early when ctx is done.
+func (wm *Warmer) Warm(ctx context.Context, urls []string, report func(WarmResult)) {
+	var tick <-chan time.Time
+	if wm.Rate > 0 {
+		// Tickers need a positive interval, and tiny rates would overflow
+		// it
+		interval := min(max(float64(time.Second)/wm.Rate, 1), float64(time.Hour))
+		ticker := time.NewTicker(time.Duration(interval))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	queue := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range min(max(wm.Concurrency, 1), maxWarmConcurrency) {
+		wg.Go(func() {
+			for u := range queue {
+				res := wm.warm(ctx, u)
+				mu.Lock()
+				report(res)
+				mu.Unlock()
+			}
+		})
+	}
+
+	defer wg.Wait()
+	defer close(queue)
+
+	for i, u := range urls {
+		if tick != nil && i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case queue <- u:
+		}
+	}
+}
+
+func (wm *Warmer) warm(ctx context.Context, rawURL string) WarmResult {
+	res := WarmResult{URL: rawURL}
+
+	w, err := wm.get(context.WithValue(ctx, warmResultKey{}, &res), rawURL, io.Discard)
+	if err != nil {
+		res.Result, res.Reason = WarmFailed, err.Error()
+		return res
+	}
+	res.Status = w.status
+
+	// Responses relayed to a cluster peer are only known by their X-Cache
+	xCache := w.Header().Get("X-Cache")
+	switch {
+	case w.status >= http.StatusInternalServerError:
+		res.Result, res.Reason = WarmFailed, fmt.Sprintf("status %d", w.status)
+	case res.Result != "":
+		// Reported by the proxy
+	case strings.HasPrefix(xCache, "HIT"), xCache == "STALE", xCache == "COALESCED":
+		res.Result = WarmCached
+	case xCache == "MISS", xCache == "REVALIDATED":
+		res.Result = WarmStored
+	default:
+		// The peer doesn't tell why
+		res.Result, res.Reason = WarmBypassed, "not cacheable"
+	}
+
+	return res
+}
+
+// get serves a GET request for rawURL with Handler, writing the body to body.
+func (wm *Warmer) get(ctx context.Context, rawURL string, body io.Writer) (*warmWriter, error) {
+	u, err := absoluteURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.RequestURI = u.RequestURI()
+	r.RemoteAddr = "cachefik-warmer"
+	r.Header.Set("User-Agent", "cachefik-warmer")
+
+	w := &warmWriter{header: http.Header{}, body: body, status: http.StatusOK}
+	if aborted := serveRecovering(wm.Handler, w, r); aborted {
+		return nil, errors.New("response aborted midway")
+	}
+
+	return w, nil
+}
+
+// serveRecovering serves r with h, reporting whether it aborted the response
+// with http.ErrAbortHandler, which the server would otherwise recover.
+func serveRecovering(h http.Handler, w http.ResponseWriter, r *http.Request) (aborted bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			if err != http.ErrAbortHandler {
+				panic(err)
+			}
+			aborted = true
+		}
+	}()
+
+	h.ServeHTTP(w, r)
+	return false
+}
+
+// URLs returns the URLs listed in data, either a sitemap, a sitemap index
+// whose sitemaps are fetched through Handler, or one URL per line.
+func (wm *Warmer) URLs(ctx context.Context, data []byte) ([]string, error) {
+	var urls []string
+	seen := make(map[string]struct{})
+	err := wm.parseList(ctx, data, 0, func(u string) {
+		if _, ok := seen[u]; !ok {
+			seen[u] = struct{}{}
+			urls = append(urls, u)
+		}
+	})
+
+	return urls, err
+}
+
+// Sitemap returns the URLs listed by the sitemap at rawURL.
+func (wm *Warmer) Sitemap(ctx context.Context, rawURL string) ([]string, error) {
+	data, err := wm.fetch(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.URLs(ctx, data)
+}
+
+func (wm *Warmer) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	var buf bytes.Buffer
+	lw := &limitedWriter{W: &buf, Limit: maxSitemapSize}
+	w, err := wm.get(ctx, rawURL, lw)
+	if err != nil {
+		return nil, err
+	}
+	if w.status != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: status %d", rawURL, w.status)
+	}
+	if lw.Exceeded {
+		return nil, fmt.Errorf("fetching %s: sitemap larger than %d bytes", rawURL, maxSitemapSize)
+	}
+
+	return buf.Bytes(), nil
+}
+
+type sitemap struct {
+	XMLName  xml.Name
+	URLs     []string `xml:"url>loc"`
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+func (wm *Warmer) parseList(ctx context.Context, data []byte, depth int, add func(string)) error {
+	// Sitemaps are commonly served gzipped as .xml.gz files
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if data, err = io.ReadAll(io.LimitReader(zr, maxSitemapSize)); err != nil {
+			return err
+		}
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				add(line)
+			}
+		}
+		return scanner.Err()
+	}
+
+	var sm sitemap
+	if err := xml.Unmarshal(data, &sm); err != nil {
+		return fmt.Errorf("invalid sitemap: %w", err)
+	}
+
+	for _, loc := range sm.URLs {
+		add(strings.TrimSpace(loc))
+	}
+
+	if len(sm.Sitemaps) > 0 && depth >= maxSitemapDepth {
+		return errors.New("sitemap indexes nested too deep")
+	}
+	for _, loc := range sm.Sitemaps {
+		child, err := wm.fetch(ctx, strings.TrimSpace(loc))
+		if err != nil {
+			return err
+		}
+		if err := wm.parseList(ctx, child, depth+1, add); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// warmWriter is the response writer of warming requests.
+type warmWriter struct {
+	header http.Header
+	body   io.Writer
+	status int
+	wrote  bool
+}
+
+func (w *warmWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *warmWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status = status
+		w.wrote = true
+	}
+}
+
+func (w *warmWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// runWarm implements the warm command, asking the admin API of a running
+// instance to warm the URLs listed in files, or in sitemaps given by URL.
+func runWarm(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("warm", flag.ContinueOnError)
+	admin := fs.String("admin", adminURL(cfg.AdminAddr), "admin API URL of the instance to warm")
+	concurrency := fs.Int("concurrency", int(cfg.WarmConcurrency), "URLs requested at once")
+	rate := fs.Float64("rate", cfg.WarmRate, "URLs requested per second, 0 for no limit")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cachefik warm [flags] <file|sitemap URL|->...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || *admin == "" {
+		fs.Usage()
+		return 2
+	}
+
+	query := url.Values{
+		"concurrency": {strconv.Itoa(*concurrency)},
+		"rate":        {strconv.FormatFloat(*rate, 'f', -1, 64)},
+	}
+	endpoint := strings.TrimSuffix(*admin, "/") + "/warm?"
+
+	// Each file is warmed on its own as sitemaps can't be concatenated, the
+	// sitemap URLs together
+	counts := make(map[string]int)
+	sitemaps := url.Values{}
+	for _, source := range fs.Args() {
+		var data []byte
+		var err error
+		switch {
+		case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+			sitemaps.Add("sitemap", source)
+			continue
+		case source == "-":
+			data, err = io.ReadAll(os.Stdin)
+		default:
+			data, err = os.ReadFile(source)
+		}
+		if err == nil {
+			err = postWarm(endpoint+query.Encode(), data, counts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", source, err)
+			return 1
+		}
+	}
+
+	if len(sitemaps) > 0 {
+		for k, v := range query {
+			sitemaps[k] = v
+		}
+		if err := postWarm(endpoint+sitemaps.Encode(), nil, counts); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	fmt.Printf("%d stored, %d cached, %d bypassed, %d failed\n",
+		counts[WarmStored], counts[WarmCached], counts[WarmBypassed], counts[WarmFailed])
+	if counts[WarmFailed] > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// postWarm sends a URL list to the warm endpoint, printing the results as
+// they arrive and counting them by result.
+func postWarm(endpoint string, list []byte, counts map[string]int) error {
+	resp, err := http.Post(endpoint, "text/plain", bytes.NewReader(list))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr APIError
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("warming failed: %s (%d)", apiErr.Error, resp.StatusCode)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var res WarmResult
+		if err := dec.Decode(&res); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		counts[res.Result]++
+		if res.Reason != "" {
+			fmt.Printf("%-8s %s (%s)\n", res.Result, res.URL, res.Reason)
+		} else {
+			fmt.Printf("%-8s %s\n", res.Result, res.URL)
+		}
+	}
+}
+
+// adminURL turns a listen address such as :8001 into a URL to reach it.
+func adminURL(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+
+	return "http://" + addr
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Nelwhix/cachefik/internal/cache"
+	"github.com/Nelwhix/cachefik/internal/provider/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmer(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := maxInFlight.Load(); n > m && !maxInFlight.CompareAndSwap(m, n); m = maxInFlight.Load() {
+		}
+
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://example.com/sitemap-pages.xml</loc></sitemap>
+  <sitemap><loc>http://example.com/sitemap-docs.xml.gz</loc></sitemap>
+</sitemapindex>`))
+		case "/sitemap-pages.xml":
+			_, _ = w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/pages/1</loc></url>
+  <url><loc> http://example.com/pages/2 </loc></url>
+</urlset>`))
+		case "/sitemap-docs.xml.gz":
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = zw.Write([]byte(`<urlset><url><loc>http://example.com/docs</loc></url><url><loc>http://example.com/pages/1</loc></url></urlset>`))
+			_ = zw.Close()
+			_, _ = w.Write(buf.Bytes())
+		case "/private":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/large":
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write(make([]byte, 2048))
+		case "/slow":
+			time.Sleep(20 * time.Millisecond)
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/coalesced":
+			time.Sleep(100 * time.Millisecond)
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write([]byte("shared response"))
+		default:
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write([]byte("response for " + r.URL.Path))
+		}
+	}))
+	defer backend.Close()
+
+	newWarmer := func() (*Warmer, *cache.MemoryCache) {
+		c := cache.NewMemoryCache(0)
+		return &Warmer{
+			Handler: &Proxy{
+				Services:     []docker.Service{{Rule: "PathPrefix(`/`)", Upstream: backend.URL}},
+				Client:       &http.Client{},
+				Cache:        c,
+				MaxCacheSize: 1024,
+			},
+			Concurrency: 2,
+		}, c
+	}
+
+	warm := func(wm *Warmer, urls ...string) map[string]WarmResult {
+		results := make(map[string]WarmResult)
+		wm.Warm(context.Background(), urls, func(res WarmResult) {
+			results[res.URL] = res
+		})
+		return results
+	}
+
+	t.Run("Results", func(t *testing.T) {
+		wm, c := newWarmer()
+
+		results := warm(wm,
+			"http://example.com/pages/1",
+			"http://example.com/private",
+			"http://example.com/broken",
+			"http://example.com/large",
+			"/relative",
+		)
+		assert.Equal(t, WarmResult{URL: "http://example.com/pages/1", Result: WarmStored, Status: http.StatusOK}, results["http://example.com/pages/1"])
+		assert.Equal(t, WarmResult{URL: "http://example.com/private", Result: WarmBypassed, Status: http.StatusOK, Reason: "no-store"}, results["http://example.com/private"])
+		assert.Equal(t, WarmResult{URL: "http://example.com/broken", Result: WarmFailed, Status: http.StatusBadGateway, Reason: "status 502"}, results["http://example.com/broken"])
+		assert.Equal(t, "larger than the max cache size", results["http://example.com/large"].Reason)
+		assert.Equal(t, WarmFailed, results["/relative"].Result)
+
+		_, ok := c.Get("GET:http://example.com/pages/1?", nil)
+		assert.True(t, ok)
+
+		results = warm(wm, "http://example.com/pages/1")
+		assert.Equal(t, WarmCached, results["http://example.com/pages/1"].Result)
+	})
+
+	t.Run("Coalesced request", func(t *testing.T) {
+		wm, c := newWarmer()
+		wm.Handler.(*Proxy).CoalesceTimeout = 1 * time.Second
+
+		leader := make(chan struct{})
+		go func() {
+			defer close(leader)
+			wm.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/coalesced", nil))
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		results := warm(wm, "http://example.com/coalesced")
+		<-leader
+		assert.Equal(t, WarmResult{URL: "http://example.com/coalesced", Result: WarmCached, Status: http.StatusOK}, results["http://example.com/coalesced"])
+		_, ok := c.Get("GET:http://example.com/coalesced?", nil)
+		assert.True(t, ok)
+	})
+
+	t.Run("Relayed responses", func(t *testing.T) {
+		// A cluster peer's proxy can't report to this one, only its X-Cache header tells
+		wm := &Warmer{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Cache", r.URL.Query().Get("x-cache"))
+		})}
+
+		for xCache, result := range map[string]string{
+			"HIT":                  WarmCached,
+			"HIT; L1=MISS; L2=HIT": WarmCached,
+			"STALE":                WarmCached,
+			"COALESCED":            WarmCached,
+			"MISS":                 WarmStored,
+			"REVALIDATED":          WarmStored,
+			"BYPASS":               WarmBypassed,
+		} {
+			u := "http://example.com/?x-cache=" + url.QueryEscape(xCache)
+			assert.Equal(t, result, warm(wm, u)[u].Result, xCache)
+		}
+	})
+
+	t.Run("Concurrency and rate", func(t *testing.T) {
+		wm, _ := newWarmer()
+		maxInFlight.Store(0)
+
+		var urls []string
+		for i := range 10 {
+			urls = append(urls, fmt.Sprintf("http://example.com/slow?%d", i))
+		}
+		warm(wm, urls...)
+		assert.Equal(t, int32(2), maxInFlight.Load())
+
+		wm.Rate = 50
+		start := time.Now()
+		warm(wm, urls[:5]...)
+		assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+
+		urls = urls[:0]
+		for i := range 2 * maxWarmConcurrency {
+			urls = append(urls, fmt.Sprintf("http://example.com/slow?many=%d", i))
+		}
+		maxInFlight.Store(0)
+		wm.Concurrency, wm.Rate = 10_000_000, 1e10
+		results := warm(wm, urls...)
+		assert.Len(t, results, len(urls))
+		assert.LessOrEqual(t, maxInFlight.Load(), int32(maxWarmConcurrency))
+	})
+
+	t.Run("URL lists", func(t *testing.T) {
+		wm, _ := newWarmer()
+
+		urls, err := wm.URLs(context.Background(), []byte("# Landing pages\nhttp://example.com/\n\nhttp://example.com/about\nhttp://example.com/\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"http://example.com/", "http://example.com/about"}, urls)
+
+		urls, err = wm.Sitemap(context.Background(), "http://example.com/sitemap.xml")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"http://example.com/pages/1", "http://example.com/pages/2", "http://example.com/docs"}, urls)
+
+		_, err = wm.URLs(context.Background(), []byte("<urlset><url>"))
+		assert.Error(t, err)
+
+		_, err = wm.Sitemap(context.Background(), "http://example.com/broken")
+		assert.Error(t, err)
+	})
+
+	t.Run("Admin endpoint", func(t *testing.T) {
+		wm, c := newWarmer()
+		a := NewAdmin(c)
+
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/warm", strings.NewReader("http://example.com/")))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		a.Warmer = wm
+		w = httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/warm?concurrency=4&sitemap=http://example.com/sitemap-pages.xml", strings.NewReader("http://example.com/\nhttp://example.com/private\n")))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+		results := make(map[string]string)
+		dec := json.NewDecoder(w.Body)
+		for dec.More() {
+			var res WarmResult
+			require.NoError(t, dec.Decode(&res))
+			results[res.URL] = res.Result
+		}
+		assert.Equal(t, map[string]string{
+			"http://example.com/":        WarmStored,
+			"http://example.com/private": WarmBypassed,
+			"http://example.com/pages/1": WarmStored,
+			"http://example.com/pages/2": WarmStored,
+		}, results)
+
+		for _, target := range []string{"/warm?concurrency=0", "/warm?concurrency=10000000", "/warm?rate=fast", "/warm"} {
+			w = httptest.NewRecorder()
+			a.ServeHTTP(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader("")))
+			assert.Equal(t, http.StatusBadRequest, w.Code, target)
+		}
+	})
+}